@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/johnmikee/manifester/pkg/naming"
+	"github.com/johnmikee/manifester/rules"
+)
+
+// classIncludes names the default include of the classes without one,
+// <name>_base, with the naming policy.
+func classIncludes(classes rules.Classes, policy *naming.Policy) error {
+	for i := range classes {
+		if classes[i].Include != "" {
+			continue
+		}
+		include, err := policy.Include(classes[i].Name + "_base")
+		if err != nil {
+			return err
+		}
+		classes[i].Include = include
+	}
+
+	return nil
+}
+
+// classify puts shared devices in their device class. The user of a shared
+// device is dropped so it gets none of the user specific includes, groups
+// or offboarding.
+func (c *Client) classify(m *MachineInfo) {
+	d := deviceFacts(m)
+	class := c.classes.Match(&d)
+	if class == nil {
+		return
+	}
+
+	c.log.Trace().Str("serial", m.Serial).Str("class", class.Name).Msg("shared device")
+	m.Class = class
+	m.Username = ""
+	m.Email = ""
+	m.UserSource = ""
+	m.Department = ""
+	m.Location = ""
+}
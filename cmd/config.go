@@ -5,22 +5,49 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/mdm/abm"
 	"github.com/johnmikee/manifester/mdm/client"
+	"github.com/johnmikee/manifester/mdm/jamf"
+	"github.com/johnmikee/manifester/mdm/kandji"
 	"github.com/johnmikee/manifester/okta"
+	"github.com/johnmikee/manifester/pkg/helpers"
 	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/pkg/naming"
+	"github.com/johnmikee/manifester/pkg/rings"
+	"github.com/johnmikee/manifester/rules"
+	"github.com/johnmikee/manifester/scim"
 	"github.com/johnmikee/yae"
+	"github.com/zalando/go-keyring"
 )
 
 type Config struct {
-	MDMToken   string `json:"mdm_token"`
-	MDMURL     string `json:"mdm_url"`
-	MDMUser    string `json:"mdm_user"`
-	MDMPass    string `json:"mdm_pass"`
-	OktaToken  string `json:"okta_token"`
-	OktaURL    string `json:"okta_url"`
-	OktaDomain string `json:"okta_domain"`
+	MDMToken           string `json:"mdm_token"`
+	MDMURL             string `json:"mdm_url"`
+	MDMUser            string `json:"mdm_user"`
+	MDMPass            string `json:"mdm_pass"`
+	KandjiToken        string `json:"kandji_token"`
+	KandjiURL          string `json:"kandji_url"`
+	JamfURL            string `json:"jamf_url"`
+	JamfUser           string `json:"jamf_user"`
+	JamfPass           string `json:"jamf_pass"`
+	ABMClientID        string `json:"abm_client_id"`
+	ABMKeyID           string `json:"abm_key_id"`
+	ABMPrivateKey      string `json:"abm_private_key"`
+	ABMPrivateKeyFile  string `json:"abm_private_key_file"`
+	OktaToken          string `json:"okta_token"`
+	OktaURL            string `json:"okta_url"`
+	OktaDomain         string `json:"okta_domain"`
+	OktaClientID       string `json:"okta_client_id"`
+	OktaPrivateKey     string `json:"okta_private_key"`
+	OktaPrivateKeyFile string `json:"okta_private_key_file"`
+	OktaKeyID          string `json:"okta_key_id"`
+	OktaScopes         string `json:"okta_scopes"`
+	SCIMToken          string `json:"scim_token"`
 }
 
 type Flags struct {
@@ -35,9 +62,200 @@ type Flags struct {
 }
 
 type Opts struct {
-	Filter      string   `json:"department-filter"`
-	Exclusions  []string `json:"exclusions"`
-	DisplayName string   `json:"display-name"`
+	Filter              string               `json:"department-filter"`
+	Exclusions          []Exclusion          `json:"exclusions"`
+	DisplayName         string               `json:"display-name"`
+	DepartmentSource    string               `json:"department-source"`
+	DepartmentAttribute string               `json:"department-attribute"`
+	DepartmentMap       map[string]string    `json:"department-map"`
+	UserSearch          string               `json:"user-search"`
+	SkipEmptyGroups     bool                 `json:"skip-empty-groups"`
+	Groups              GroupOpts            `json:"okta-groups"`
+	Naming              *naming.Policy       `json:"naming"`
+	SCIMStore           string               `json:"scim-store"`
+	Rules               string               `json:"rules"`
+	Catalogs            *rules.CatalogPolicy `json:"catalogs"`
+	Rings               *rings.Config        `json:"rings"`
+	Overrides           string               `json:"overrides"`
+	Onboarding          *OnboardingOpts      `json:"onboarding"`
+	Offboarding         *OffboardingOpts     `json:"offboarding"`
+	LocationAttribute   string               `json:"location-attribute"`
+	ManagerAttribute    string               `json:"manager-attribute"`
+	Org                 *OrgOpts             `json:"org"`
+	Sites               *SiteOpts            `json:"sites"`
+	DeviceDetails       bool                 `json:"device-details"`
+	BlueprintDepts      bool                 `json:"blueprint-departments"`
+	Platforms           []string             `json:"platforms"`
+	InactiveDevices     *InactiveOpts        `json:"inactive-devices"`
+	ConsoleUsers        *ConsoleUserOpts     `json:"console-users"`
+	DeviceClasses       rules.Classes        `json:"device-classes"`
+	MDMPrecedence       mdm.Precedence       `json:"mdm-precedence"`
+	ABM                 *ABMOpts             `json:"abm"`
+	WriteBack           *WriteBackOpts       `json:"write-back"`
+	Profile             *ProfileOpts         `json:"profile"`
+}
+
+// Exclusion is a serial number that manifester does not manage. It can be
+// given as just the serial or as an object with an expiry.
+type Exclusion struct {
+	Serial  string     `json:"serial"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (e *Exclusion) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &e.Serial)
+	}
+
+	type exclusion Exclusion
+	return json.Unmarshal(data, (*exclusion)(e))
+}
+
+// activeExclusions returns the serials excluded at now and the serials
+// whose exclusion has expired.
+func activeExclusions(exclusions []Exclusion, now time.Time) ([]string, []string) {
+	var active, expired []string
+	for _, e := range exclusions {
+		if e.Expires != nil && !now.Before(*e.Expires) {
+			expired = append(expired, e.Serial)
+			continue
+		}
+		active = append(active, e.Serial)
+	}
+
+	return active, expired
+}
+
+// includes returns the include manifests and templates set in the config.
+// The site include is checked with a sample site.
+func (o *Opts) includes() []string {
+	var res []string
+	add := func(includes ...string) {
+		for _, include := range includes {
+			if include != "" {
+				res = append(res, include)
+			}
+		}
+	}
+
+	if o.Onboarding != nil {
+		add(o.Onboarding.Include)
+	}
+	if o.Offboarding != nil {
+		add(o.Offboarding.Include)
+	}
+	if o.Org != nil {
+		for _, n := range o.Org.Nodes {
+			add(n.Include)
+		}
+	}
+	if o.Sites != nil {
+		add(o.Sites.Prefix+"site", o.Sites.Template)
+	}
+	if o.Rings != nil {
+		for _, r := range o.Rings.Rings {
+			add(r.Include)
+		}
+	}
+	for _, c := range o.DeviceClasses {
+		add(c.Include)
+	}
+
+	return res
+}
+
+// ABMOpts configure the Apple Business Manager or Apple School Manager
+// device source. The endpoints default to apple's and can point at a local
+// server for testing.
+type ABMOpts struct {
+	School   bool     `json:"school"`    // apple school manager
+	Servers  []string `json:"servers"`   // only devices assigned to these mdm servers
+	URL      string   `json:"url"`       // api url
+	TokenURL string   `json:"token-url"` // oauth token url
+	Audience string   `json:"audience"`  // audience of the client assertion
+}
+
+// GroupOpts select the groups used as departments in addition to the
+// department-filter prefix.
+type GroupOpts struct {
+	Include []string `json:"include"` // group name must match one of these expressions
+	Exclude []string `json:"exclude"` // group names matching these expressions are skipped
+	Types   []string `json:"types"`   // OKTA_GROUP, APP_GROUP or BUILT_IN
+	Search  string   `json:"search"`  // okta search expression
+}
+
+// groupFilter compiles the group options into an okta.GroupFilter.
+func (o *Opts) groupFilter() (*okta.GroupFilter, error) {
+	f := &okta.GroupFilter{
+		Prefix: o.Filter,
+		Types:  o.Groups.Types,
+	}
+
+	for _, expr := range o.Groups.Include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include expression %q: %w", expr, err)
+		}
+		f.Include = append(f.Include, re)
+	}
+	for _, expr := range o.Groups.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude expression %q: %w", expr, err)
+		}
+		f.Exclude = append(f.Exclude, re)
+	}
+
+	return f, nil
+}
+
+// department sources
+const (
+	sourceOkta        = "okta"
+	sourceOktaProfile = "okta-profile"
+	sourceSCIM        = "scim"
+	sourceMDM         = "mdm"
+	sourceBoth        = "both" // okta then mdm
+)
+
+// departmentSources parses the comma separated department sources in order
+// of precedence. It returns the identity source, used for everything but
+// departments, and the department sources. At most one source other than
+// mdm may be given, mdm is the identity source when it is the only one.
+func departmentSources(s string) (string, []string, error) {
+	var sources []string
+	add := func(source string) {
+		if !helpers.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+
+	identity := ""
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+		switch source {
+		case sourceBoth:
+			add(sourceOkta)
+			add(sourceMDM)
+			source = sourceOkta
+		case sourceOkta, sourceOktaProfile, sourceSCIM:
+			add(source)
+		case sourceMDM:
+			add(source)
+			continue
+		default:
+			return "", nil, fmt.Errorf("unknown department source %q", source)
+		}
+		if identity != "" && identity != source {
+			return "", nil, fmt.Errorf("department sources %s and %s cannot be combined", identity, source)
+		}
+		identity = source
+	}
+	if identity == "" {
+		identity = sourceMDM
+	}
+
+	return identity, sources, nil
 }
 
 func readConf(cf string) *Opts {
@@ -54,6 +272,38 @@ func readConf(cf string) *Opts {
 		return nil
 	}
 
+	if opts.DepartmentSource == "" {
+		opts.DepartmentSource = sourceOkta
+	}
+	if opts.DepartmentAttribute == "" {
+		opts.DepartmentAttribute = "department"
+	}
+	if opts.UserSearch == "" {
+		opts.UserSearch = `status eq "ACTIVE"`
+	}
+	if opts.SCIMStore == "" {
+		opts.SCIMStore = "scim.json"
+	}
+	if opts.LocationAttribute == "" {
+		opts.LocationAttribute = "city"
+	}
+	if opts.ManagerAttribute == "" {
+		opts.ManagerAttribute = "managerId"
+	}
+	opts.Onboarding.setDefaults()
+	opts.Org.setDefaults()
+	opts.Sites.setDefaults()
+	opts.Offboarding.setDefaults()
+	opts.InactiveDevices.setDefaults()
+	opts.WriteBack.setDefaults()
+	opts.Profile.setDefaults()
+	if opts.MDMPrecedence == "" {
+		opts.MDMPrecedence = mdm.PrecedenceOrder
+	}
+	if len(opts.Platforms) == 0 {
+		opts.Platforms = []string{"Mac"}
+	}
+
 	return &opts
 }
 
@@ -102,7 +352,7 @@ func setup() *Client {
 		&f.mdm,
 		"mdm",
 		f.mdm,
-		"Select which mdm [jamf | kandji].",
+		"Select which mdm [jamf | kandji | abm], or a comma separated list to merge several.",
 	)
 	flag.StringVar(
 		&f.manifestDir,
@@ -126,14 +376,7 @@ func setup() *Client {
 		},
 	)
 
-	var cfg Config
-	err := yae.Get(yae.PROD,
-		&yae.Env{
-			Name:         f.service,
-			Type:         yae.JSON,
-			ConfigStruct: &cfg,
-		},
-	)
+	cfg, err := getConfig(f.service)
 	if err != nil {
 		log.Fatal().AnErr("error", err).Msg("failed to get config")
 	}
@@ -149,36 +392,313 @@ func setup() *Client {
 		return nil
 	}
 
+	err = opts.Naming.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid naming policy")
+	}
+
+	ruleset := rules.Default()
+	if opts.Rules != "" {
+		ruleset, err = rules.Load(opts.Rules)
+		if err != nil {
+			log.Fatal().AnErr("error", err).Str("rules", opts.Rules).Msg("failed to load rules")
+		}
+	}
+
+	err = validateIncludes(f.manifestDir, ruleset.Includes())
+	if err != nil {
+		log.Fatal().AnErr("error", err).Str("rules", opts.Rules).Msg("invalid include manifest in rules")
+	}
+
+	err = opts.Catalogs.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid catalog policy")
+	}
+
+	err = opts.Offboarding.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid offboarding policy")
+	}
+
+	err = classIncludes(opts.DeviceClasses, opts.Naming)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid device class include")
+	}
+
+	err = validateIncludes(f.manifestDir, opts.includes())
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid include manifest")
+	}
+
+	err = opts.DeviceClasses.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid device classes")
+	}
+
+	err = opts.InactiveDevices.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid inactive device policy")
+	}
+
+	err = opts.Org.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid org tree")
+	}
+
+	err = opts.Rings.Validate()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid rollout rings")
+	}
+
+	var overrides *rules.Overrides
+	if opts.Overrides != "" {
+		overrides, err = rules.LoadOverrides(opts.Overrides)
+		if err != nil {
+			log.Fatal().AnErr("error", err).Str("overrides", opts.Overrides).Msg("failed to load overrides")
+		}
+	}
+
+	err = validateIncludes(f.manifestDir, overrides.Includes())
+	if err != nil {
+		log.Fatal().AnErr("error", err).Str("overrides", opts.Overrides).Msg("invalid include manifest in overrides")
+	}
+
+	mdms, err := mdmProviders(f.mdm, f.service, &cfg, opts, log)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid mdm")
+	}
+
+	source, sources, err := departmentSources(opts.DepartmentSource)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid department source")
+	}
+
+	groupFilter, err := opts.groupFilter()
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("failed to build group filter")
+	}
+
+	var oktaKey []byte
+	if cfg.OktaClientID != "" {
+		oktaKey, err = oktaPrivateKey(f.service, &cfg)
+		if err != nil {
+			log.Fatal().AnErr("error", err).Msg("failed to load okta private key")
+		}
+	}
+
+	display, err := displayTemplate(opts.DisplayName)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("invalid display-name template")
+	}
+
+	exclusions, expiredExclusions := activeExclusions(opts.Exclusions, time.Now())
+
 	client := &Client{
-		directory:  f.manifestDir,
-		exclusions: opts.Exclusions,
-		filter:     opts.Filter,
-		log:        &log,
-		mdm: client.New(
-			&client.MDM{
-				MDM: mdm.MDM(f.mdm),
-				Config: mdm.Config{
-					MDM:                    mdm.MDM(f.mdm),
-					URL:                    cfg.MDMURL,
-					User:                   cfg.MDMUser,
-					Password:               cfg.MDMPass,
-					Token:                  cfg.MDMToken,
-					Client:                 nil,
-					Log:                    log,
-					ProviderSpecificConfig: nil,
-				},
-			},
-		),
+		directory:   f.manifestDir,
+		exclusions:  exclusions,
+		filter:      groupFilter,
+		source:      source,
+		naming:      opts.Naming,
+		rules:       ruleset,
+		rulesFile:   opts.Rules,
+		catalogs:    opts.Catalogs,
+		rings:       opts.Rings,
+		overrides:   overrides,
+		onboarding:  opts.Onboarding,
+		offboarding: opts.Offboarding,
+		org:         opts.Org,
+		sites:       opts.Sites,
+		display:     display,
+		platforms:   opts.Platforms,
+		inactive:    opts.InactiveDevices,
+		console:     opts.ConsoleUsers,
+		classes:     opts.DeviceClasses,
+		writeBack:   opts.WriteBack,
+		dryRun:      f.dryRun,
+
+		locationAttribute: opts.LocationAttribute,
+		departmentSources: sources,
+		managerAttribute:  opts.ManagerAttribute,
+
+		listGroups: &okta.ListGroupsOptions{
+			Stats:  opts.SkipEmptyGroups,
+			Search: opts.Groups.Search,
+		},
+		profile: profileOpts{
+			attribute: opts.DepartmentAttribute,
+			mapping:   opts.DepartmentMap,
+			search:    opts.UserSearch,
+		},
+		log: &log,
+		mdm: client.NewMulti(mdms, opts.MDMPrecedence),
 		okta: okta.New(
 			&okta.Config{
-				Domain: cfg.OktaDomain,
-				Token:  cfg.OktaToken,
-				URL:    cfg.OktaURL,
-				Log:    &log,
-				Client: nil,
+				Domain:     cfg.OktaDomain,
+				Token:      cfg.OktaToken,
+				URL:        cfg.OktaURL,
+				ClientID:   cfg.OktaClientID,
+				PrivateKey: oktaKey,
+				KeyID:      cfg.OktaKeyID,
+				Scopes:     strings.Fields(strings.ReplaceAll(cfg.OktaScopes, ",", " ")),
+				Log:        &log,
+				Client:     nil,
 			},
 		),
 	}
 
+	client.report.expire("exclusion", expiredExclusions...)
+
+	if client.source == sourceSCIM {
+		client.scim, err = scim.Open(opts.SCIMStore)
+		if err != nil {
+			log.Fatal().AnErr("error", err).Str("store", opts.SCIMStore).Msg("failed to open scim store")
+		}
+	}
+
 	return client
 }
+
+// mdmProviders returns the client configuration of each mdm in the comma
+// separated list. Credentials named after the mdm, e.g. kandji_token, are
+// used over the shared mdm_ ones so each mdm can have its own.
+func mdmProviders(list, service string, cfg *Config, opts *Opts, log logger.Logger) ([]*client.MDM, error) {
+	switch opts.MDMPrecedence {
+	case mdm.PrecedenceOrder, mdm.PrecedenceCheckIn:
+	default:
+		return nil, fmt.Errorf("unknown mdm precedence %q", opts.MDMPrecedence)
+	}
+
+	var res []*client.MDM
+	for _, name := range strings.Split(list, ",") {
+		m := mdm.MDM(strings.TrimSpace(name))
+		c := mdm.Config{
+			MDM:                    m,
+			URL:                    cfg.MDMURL,
+			User:                   cfg.MDMUser,
+			Password:               cfg.MDMPass,
+			Token:                  cfg.MDMToken,
+			Client:                 nil,
+			Log:                    log,
+			ProviderSpecificConfig: mdmConfig(m, opts),
+		}
+		switch m {
+		case mdm.Kandji:
+			c.URL = firstNonEmpty(cfg.KandjiURL, c.URL)
+			c.Token = firstNonEmpty(cfg.KandjiToken, c.Token)
+		case mdm.Jamf:
+			c.URL = firstNonEmpty(cfg.JamfURL, c.URL)
+			c.User = firstNonEmpty(cfg.JamfUser, c.User)
+			c.Password = firstNonEmpty(cfg.JamfPass, c.Password)
+		case mdm.ABM:
+			ac, err := abmConfig(service, cfg, opts.ABM)
+			if err != nil {
+				return nil, err
+			}
+			c.URL = ""
+			if opts.ABM != nil {
+				c.URL = opts.ABM.URL
+			}
+			c.ProviderSpecificConfig = ac
+		default:
+			return nil, fmt.Errorf("unknown mdm %q", m)
+		}
+		res = append(res, &client.MDM{MDM: m, Config: c})
+	}
+
+	return res, nil
+}
+
+// abmConfig builds the client configuration of the apple business manager
+// api account.
+func abmConfig(service string, cfg *Config, opts *ABMOpts) (*abm.Config, error) {
+	if cfg.ABMClientID == "" || cfg.ABMKeyID == "" {
+		return nil, fmt.Errorf("abm needs abm_client_id and abm_key_id")
+	}
+	key, err := privateKey(service, "abm_private_key", cfg.ABMPrivateKey, cfg.ABMPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &abm.Config{
+		ClientID:   cfg.ABMClientID,
+		KeyID:      cfg.ABMKeyID,
+		PrivateKey: key,
+	}
+	if opts != nil {
+		ac.School = opts.School
+		ac.Servers = opts.Servers
+		ac.TokenURL = opts.TokenURL
+		ac.Audience = opts.Audience
+	}
+
+	return ac, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// mdmConfig returns the provider specific configuration for the mdm.
+func mdmConfig(m mdm.MDM, opts *Opts) interface{} {
+	switch m {
+	case mdm.Kandji:
+		kc := &kandji.Config{Details: opts.DeviceDetails, BlueprintDepartments: opts.BlueprintDepts}
+		if opts.WriteBack != nil {
+			kc.TagPrefix = opts.WriteBack.TagPrefix
+		}
+		return kc
+	case mdm.Jamf:
+		if opts.WriteBack == nil {
+			return nil
+		}
+		return &jamf.Config{Attribute: opts.WriteBack.Attribute}
+	default:
+		return nil
+	}
+}
+
+// oktaPrivateKey returns the PEM encoded key used to sign the okta client
+// assertion. The key is taken from okta_private_key, then the file set in
+// okta_private_key_file and finally the okta_private_key entry for the
+// service in the system keyring.
+func oktaPrivateKey(service string, cfg *Config) ([]byte, error) {
+	return privateKey(service, "okta_private_key", cfg.OktaPrivateKey, cfg.OktaPrivateKeyFile)
+}
+
+// privateKey returns the PEM encoded key from the value, then the file and
+// finally the named entry for the service in the system keyring.
+func privateKey(service, name, value, file string) ([]byte, error) {
+	if value != "" {
+		return []byte(value), nil
+	}
+
+	if file != "" {
+		return os.ReadFile(file)
+	}
+
+	key, err := keyring.Get(name, service)
+	if err != nil {
+		return nil, fmt.Errorf("no %s configured: %w", name, err)
+	}
+
+	return []byte(key), nil
+}
+
+func getConfig(service string) (Config, error) {
+	var cfg Config
+	err := yae.Get(yae.PROD,
+		&yae.Env{
+			Name:         service,
+			Type:         yae.JSON,
+			ConfigStruct: &cfg,
+		},
+	)
+
+	return cfg, err
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/johnmikee/manifester/mdm"
+)
+
+// user sources recorded as user_source under _metadata
+const (
+	userSourceMDM     = "mdm"
+	userSourceConsole = "console"
+)
+
+// ConsoleUserOpts resolve the user of devices without an assigned user in
+// the mdm from their local accounts.
+type ConsoleUserOpts struct {
+	// Map maps local account names to directory usernames or emails, for
+	// accounts that are not named after the user.
+	Map map[string]string `json:"map"`
+	// Ignore lists local accounts that never belong to a user, e.g. admin.
+	Ignore []string `json:"ignore"`
+}
+
+// consoleUsers assigns a user to the machines without one from the primary
+// local account reported by the mdm.
+func (c *Client) consoleUsers(machines []MachineInfo) {
+	if c.console == nil {
+		return
+	}
+	provider, ok := c.mdm.(mdm.ConsoleUserProvider)
+	if !ok {
+		c.log.Info().Msg("mdm does not report console users")
+		return
+	}
+
+	var unassigned []mdm.Device
+	for _, m := range machines {
+		if m.Username == "" {
+			unassigned = append(unassigned, m.Device)
+		}
+	}
+	if len(unassigned) == 0 {
+		return
+	}
+
+	users := provider.ConsoleUsers(unassigned)
+	for i := range machines {
+		m := &machines[i]
+		u, ok := users[m.Serial]
+		if m.Username != "" || !ok {
+			continue
+		}
+		account := u.Primary()
+		email, ok := c.consoleUser(account)
+		if !ok {
+			c.log.Debug().Str("serial", m.Serial).Str("account", account).Msg("no directory user for console user")
+			continue
+		}
+		m.Email = email
+		m.Username = username(email)
+		m.UserSource = userSourceConsole + ":" + account
+	}
+}
+
+// consoleUser resolves a local account to the email of a directory user.
+// Mapped emails are used as is, other accounts must match a username in
+// the directory.
+func (c *Client) consoleUser(account string) (string, bool) {
+	if account == "" {
+		return "", false
+	}
+	for _, ignored := range c.console.Ignore {
+		if strings.EqualFold(ignored, account) {
+			return "", false
+		}
+	}
+
+	name := strings.ToLower(account)
+	for local, mapped := range c.console.Map {
+		if !strings.EqualFold(local, account) {
+			continue
+		}
+		if u, ok := c.people()[username(mapped)]; ok {
+			return u.email, true
+		}
+		if strings.Contains(mapped, "@") {
+			return mapped, true
+		}
+		name = strings.ToLower(mapped)
+	}
+
+	u, ok := c.people()[name]
+	if !ok || u.email == "" {
+		return "", false
+	}
+
+	return u.email, true
+}
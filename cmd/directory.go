@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// directoryUser holds the identity provider attributes of a user that rules
+// can match on.
+type directoryUser struct {
+	id         string
+	login      string
+	email      string
+	department string
+	title      string
+	location   string
+	startDate  string
+	site       string // location used for the site include
+	manager    string // id, login, email or employee number of the manager
+	employeeNo string
+}
+
+// people returns the directory users keyed by the lower cased username, the
+// part of the email before the @. Users are only fetched once per run.
+func (c *Client) people() map[string]directoryUser {
+	if c.directoryUsers != nil {
+		return c.directoryUsers
+	}
+	c.directoryUsers = make(map[string]directoryUser)
+
+	switch c.source {
+	case sourceMDM:
+		// without a directory there are no people to look up
+	case sourceSCIM:
+		for _, u := range c.scim.Users() {
+			if !u.Active {
+				continue
+			}
+			email := u.PrimaryEmail()
+			du := directoryUser{
+				id:    u.ID,
+				login: u.UserName,
+				email: email,
+				title: u.Title,
+			}
+			if u.Enterprise != nil {
+				du.department = u.Enterprise.Department
+				du.employeeNo = u.Enterprise.EmployeeNumber
+				if u.Enterprise.Manager != nil {
+					du.manager = u.Enterprise.Manager.Value
+				}
+			}
+			c.directoryUsers[username(email)] = du
+		}
+	default:
+		users, err := c.okta.ListUsers(c.profile.search)
+		if err != nil {
+			c.log.Info().AnErr("error", err).Msg("failed to get okta users")
+			return c.directoryUsers
+		}
+		for _, u := range users {
+			c.directoryUsers[username(u.Profile.Email)] = directoryUser{
+				id:         u.ID,
+				login:      u.Profile.Login,
+				email:      u.Profile.Email,
+				department: u.Profile.Department,
+				title:      u.Profile.Title,
+				location:   u.Profile.Attribute(c.locationAttribute),
+				startDate:  u.Profile.Attribute(c.startDateAttribute()),
+				manager:    u.Profile.Attribute(c.managerAttribute),
+				employeeNo: u.Profile.Attribute("employeeNumber"),
+				site:       u.Profile.Attribute(c.siteAttribute()),
+			}
+		}
+	}
+
+	return c.directoryUsers
+}
+
+// needPeople reports whether the directory users are needed, they are only
+// fetched for custom rules, onboarding, the org tree and sites.
+func (c *Client) needPeople() bool {
+	return c.rulesFile != "" || c.onboarding != nil || c.org != nil || c.siteAttribute() != ""
+}
+
+// managers returns the emails of the management chain of the user, the
+// direct manager first. The manager of a user may be referenced by their
+// id, login, email or employee number.
+func (c *Client) managers(user string) []string {
+	people := c.people()
+	if c.managerIndex == nil {
+		c.managerIndex = make(map[string]string)
+		for name, u := range people {
+			for _, key := range []string{u.id, u.login, u.email, u.employeeNo} {
+				if key != "" {
+					c.managerIndex[strings.ToLower(key)] = name
+				}
+			}
+		}
+	}
+
+	var chain []string
+	seen := map[string]bool{user: true}
+	for {
+		u, ok := people[user]
+		if !ok || u.manager == "" {
+			break
+		}
+		next, ok := c.managerIndex[strings.ToLower(u.manager)]
+		if !ok || seen[next] {
+			break
+		}
+		seen[next] = true
+		chain = append(chain, people[next].email)
+		user = next
+	}
+
+	return chain
+}
+
+func (c *Client) startDateAttribute() string {
+	if c.onboarding == nil {
+		return "startDate"
+	}
+
+	return c.onboarding.Attribute
+}
+
+func (c *Client) siteAttribute() string {
+	if c.sites == nil {
+		return ""
+	}
+
+	return c.sites.Attribute
+}
+
+// username returns the lower cased part of the email before the @.
+func username(email string) string {
+	return strings.ToLower(strings.Split(email, "@")[0])
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/johnmikee/manifester/rules"
+)
+
+// displayTemplate parses the display-name option. Values without a template
+// action keep the default display name, the username of the assigned user
+// or the device class.
+//
+//	{{.User.Username}} - {{.Device.Model}} ({{.Device.Architecture}})
+func displayTemplate(displayName string) (*template.Template, error) {
+	if !strings.Contains(displayName, "{{") {
+		return nil, nil
+	}
+
+	t, err := template.New("display-name").Parse(displayName)
+	if err != nil {
+		return nil, err
+	}
+
+	// catch references to unknown fields before any manifests are written
+	if err := t.Execute(&bytes.Buffer{}, &rules.Facts{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// displayName returns the display_name of the manifest, the username or the
+// device class of shared devices.
+func (c *Client) displayName(f *rules.Facts) string {
+	name := f.User.Username
+	if name == "" {
+		name = f.Device.Class
+	}
+	if c.display == nil || name == "" {
+		return name
+	}
+
+	var b bytes.Buffer
+	if err := c.display.Execute(&b, f); err != nil {
+		c.log.Info().AnErr("error", err).Str("serial", f.Device.Serial).Msg("failed to render display name")
+		return name
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -6,13 +6,15 @@ import (
 	"github.com/johnmikee/manifester/pkg/helpers"
 )
 
-func (c *Client) removeEntries() error {
+// removeEntries removes the manifests in the directory except those of
+// excluded devices and the serials to keep.
+func (c *Client) removeEntries(keep ...string) error {
 	files, err := os.ReadDir(c.directory)
 	if err != nil {
 		return err
 	}
 	for _, file := range files {
-		if !helpers.Contains(c.exclusions, file.Name()) {
+		if !helpers.Contains(c.exclusions, file.Name()) && !helpers.Contains(keep, file.Name()) {
 			filePath := c.directory + "/" + file.Name()
 			// make sure its not a directory
 			if file.IsDir() {
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// inactive device actions
+const (
+	inactiveSkip    = "skip"
+	inactiveArchive = "archive"
+	// inactiveArchived is reported for devices archived on an earlier run.
+	inactiveArchived = "archived"
+)
+
+// inactive device reasons
+const (
+	reasonRemoved = "removed"
+	reasonMissing = "missing"
+	reasonStale   = "stale"
+)
+
+// InactiveOpts control the manifests of devices that were removed from the
+// mdm, marked as missing or have not checked in for a while.
+type InactiveOpts struct {
+	// Action is skip, to leave the manifest alone, or archive to move an
+	// existing manifest to the archive directory. Default skip.
+	Action string `json:"action"`
+	// Removed handles devices removed from the mdm.
+	Removed bool `json:"removed"`
+	// Missing handles devices marked as lost or missing.
+	Missing bool `json:"missing"`
+	// StaleDays handles devices that have not checked in for this many
+	// days, 0 disables it.
+	StaleDays int `json:"stale-days"`
+	// ArchiveDir receives the archived manifests. Relative paths are
+	// relative to the manifest directory, default archive.
+	ArchiveDir string `json:"archive-dir"`
+}
+
+func (o *InactiveOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if o.Action == "" {
+		o.Action = inactiveSkip
+	}
+	if o.ArchiveDir == "" {
+		o.ArchiveDir = "archive"
+	}
+}
+
+// Validate checks the action is known.
+func (o *InactiveOpts) Validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Action {
+	case inactiveSkip, inactiveArchive:
+	default:
+		return fmt.Errorf("unknown inactive device action %q", o.Action)
+	}
+	if o.StaleDays < 0 {
+		return fmt.Errorf("stale-days must not be negative")
+	}
+
+	return nil
+}
+
+// reason returns why the device is inactive at now, or an empty string.
+// Devices that never checked in are not stale.
+func (o *InactiveOpts) reason(d *mdm.Device, now time.Time) string {
+	switch {
+	case o == nil:
+		return ""
+	case o.Removed && d.Removed:
+		return reasonRemoved
+	case o.Missing && d.Missing:
+		return reasonMissing
+	case o.StaleDays > 0 && !d.LastCheckIn.IsZero() &&
+		now.Sub(d.LastCheckIn) > time.Duration(o.StaleDays)*24*time.Hour:
+		return reasonStale
+	}
+
+	return ""
+}
+
+// supportedPlatform reports whether manifests are written for the platform.
+// Devices without a platform are kept since not every mdm reports it.
+func (c *Client) supportedPlatform(platform string) bool {
+	if platform == "" || len(c.platforms) == 0 {
+		return true
+	}
+	for _, p := range c.platforms {
+		if strings.EqualFold(p, platform) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// archive moves the manifest of an inactive device to the archive directory.
+// It reports whether there was a manifest to move.
+func (c *Client) archive(serial string) (bool, error) {
+	return c.moveManifest(serial, c.directory, c.manifestSubdir(c.inactive.ArchiveDir))
+}
+
+// archived reports whether the manifest of the device is in the archive
+// directory.
+func (c *Client) archived(serial string) bool {
+	_, err := os.Stat(filepath.Join(c.manifestSubdir(c.inactive.ArchiveDir), serial))
+
+	return err == nil
+}
+
+// inactiveSerials returns the devices inactive at now whose manifest is
+// archived, they are kept when the manifests are removed at the start of a
+// run so there is something to archive.
+func (c *Client) inactiveSerials(machines []MachineInfo, now time.Time) []string {
+	if c.inactive == nil || c.inactive.Action != inactiveArchive {
+		return nil
+	}
+
+	var res []string
+	for i := range machines {
+		m := &machines[i]
+		if c.supportedPlatform(m.Device.Platform) && c.inactive.reason(&m.Device, now) != "" {
+			res = append(res, m.Serial)
+		}
+	}
+
+	return res
+}
+
+// restore drops the archived manifest once the device is active again, the
+// archived copy is out of date and a new manifest is written in its place.
+func (c *Client) restore(serial string) {
+	if c.inactive == nil || c.inactive.Action != inactiveArchive {
+		return
+	}
+
+	err := os.Remove(filepath.Join(c.manifestSubdir(c.inactive.ArchiveDir), serial))
+	if err == nil {
+		c.log.Debug().Str("serial", serial).Msg("removed archived manifest of active device")
+	} else if !os.IsNotExist(err) {
+		c.log.Info().AnErr("error", err).Str("serial", serial).Msg("failed to remove archived manifest")
+	}
+}
+
+// moveManifest moves the manifest for the serial between directories, it
+// never overwrites a manifest in the destination.
+func (c *Client) moveManifest(serial, from, to string) (bool, error) {
+	src := filepath.Join(from, serial)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	dest := filepath.Join(to, serial)
+	if _, err := os.Stat(dest); err == nil {
+		return false, fmt.Errorf("manifest %s already exists", dest)
+	}
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(src, dest)
+}
+
+// inactiveDevice handles a device that is removed, missing or stale and
+// records it in the report.
+func (c *Client) inactiveDevice(v *MachineInfo, reason string, current []string) {
+	action := c.inactive.Action
+	if action == inactiveArchive {
+		switch {
+		case helpers.Contains(current, v.Serial):
+			if _, err := c.archive(v.Serial); err != nil {
+				c.log.Info().AnErr("error", err).Str("serial", v.Serial).Msg("failed to archive manifest")
+				c.report.failed++
+				return
+			}
+		case c.archived(v.Serial):
+			action = inactiveArchived
+		default:
+			action = inactiveSkip
+		}
+	}
+	c.report.deactivate(v.Serial, reason, action)
+}
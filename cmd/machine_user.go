@@ -1,12 +1,24 @@
 package cmd
 
 import (
+	"regexp"
 	"strings"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/okta"
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/rules"
 )
 
 type MachineInfo struct {
-	Serial   string
-	Username string
+	Serial     string
+	Username   string
+	Email      string
+	UserSource string       // how the user was resolved, mdm or console:<account>
+	Department string       // department recorded in the mdm
+	Location   string       // location recorded in the mdm, e.g. the building
+	Class      *rules.Class // device class of a shared device
+	Device     mdm.Device
 }
 
 func (c *Client) getDevices() ([]MachineInfo, error) {
@@ -19,9 +31,14 @@ func (c *Client) getDevices() ([]MachineInfo, error) {
 	for _, machine := range machines {
 		m := MachineInfo{
 			Serial: machine.Device.SerialNumber,
+			Device: machine.Device,
 		}
 		if machine.Users != nil {
+			m.Email = machine.Users.Email
 			m.Username = strings.Split(machine.Users.Email, "@")[0]
+			m.UserSource = userSourceMDM
+			m.Department = machine.Users.Department
+			m.Location = machine.Users.Location
 		} else {
 			m.Username = ""
 		}
@@ -29,17 +46,181 @@ func (c *Client) getDevices() ([]MachineInfo, error) {
 		manifestMachines = append(manifestMachines, m)
 	}
 
+	c.consoleUsers(manifestMachines)
+	c.mdmDepartments = c.mdmMembers(manifestMachines)
+
 	return manifestMachines, nil
 }
 
-func (c *Client) oktaGroupMembers(filter string) map[string][]string {
-	oktaGroups, err := c.okta.ListGroups()
+// mdmMembers groups the emails of the device users by the department
+// recorded in the mdm. Departments are translated through the department
+// mapping table like profile values.
+func (c *Client) mdmMembers(machines []MachineInfo) map[string][]string {
+	gm := make(map[string][]string)
+	for _, m := range machines {
+		if m.Department == "" || m.Email == "" {
+			continue
+		}
+		dept, ok := c.profile.mapping[m.Department]
+		if !ok {
+			dept = m.Department
+		}
+		if dept == "" || helpers.Contains(gm[dept], m.Email) {
+			continue
+		}
+		gm[dept] = append(gm[dept], m.Email)
+	}
+
+	return gm
+}
+
+// groupMembers returns a map of department names to the emails of their
+// members from the configured department sources. With more than one
+// source a user only gets the departments of the first source listing them.
+func (c *Client) groupMembers() map[string][]string {
+	sources := c.departmentSources
+	if len(sources) == 0 {
+		sources = []string{c.source}
+	}
+
+	gm := make(map[string][]string)
+	assigned := make(map[string]bool)
+	for _, source := range sources {
+		found := make(map[string]bool)
+		for dept, members := range c.sourceMembers(source) {
+			// keep departments whose members are all assigned by an
+			// earlier source, their include manifest is still created
+			if _, ok := gm[dept]; !ok {
+				gm[dept] = nil
+			}
+			for _, member := range members {
+				user := username(member)
+				if assigned[user] {
+					continue
+				}
+				found[user] = true
+				gm[dept] = append(gm[dept], member)
+			}
+		}
+		for user := range found {
+			assigned[user] = true
+		}
+	}
+
+	return gm
+}
+
+func (c *Client) sourceMembers(source string) map[string][]string {
+	switch source {
+	case sourceOktaProfile:
+		return c.oktaProfileMembers()
+	case sourceSCIM:
+		return c.scimGroupMembers(c.filter)
+	case sourceMDM:
+		return c.mdmDepartments
+	default:
+		return c.oktaGroupMembers(c.filter)
+	}
+}
+
+func (c *Client) scimGroupMembers(filter *okta.GroupFilter) map[string][]string {
+	gm := make(map[string][]string)
+	for group, members := range c.scim.GroupMembers() {
+		if !filter.MatchName(group) {
+			continue
+		}
+		gm[group] = members
+	}
+
+	return gm
+}
+
+func (c *Client) oktaGroupMembers(filter *okta.GroupFilter) map[string][]string {
+	oktaGroups, err := c.okta.ListGroups(c.listGroups)
 	if err != nil {
 		c.log.Info().AnErr("error", err).Msg("failed to get okta groups")
 		return nil
 	}
 
-	gm := oktaGroups.GetMembers(c.okta, &filter)
+	gm := oktaGroups.GetMembers(c.okta, filter)
 
 	return gm
 }
+
+// oktaProfileMembers lists the okta users and groups them by the value of the
+// configured profile attribute. Values are translated through the mapping
+// table when present, values mapped to an empty string are skipped.
+func (c *Client) oktaProfileMembers() map[string][]string {
+	users, err := c.okta.ListUsers(c.profile.search)
+	if err != nil {
+		c.log.Info().AnErr("error", err).Msg("failed to get okta users")
+		return nil
+	}
+
+	gm := make(map[string][]string)
+	for _, user := range users {
+		value := user.Profile.Attribute(c.profile.attribute)
+		if value == "" {
+			continue
+		}
+
+		dept, ok := c.profile.mapping[value]
+		if !ok {
+			dept = value
+		}
+		if dept == "" {
+			c.log.Trace().Str("value", value).Str("user", user.Profile.Email).Msg("skipping unmapped department")
+			continue
+		}
+
+		gm[dept] = append(gm[dept], user.Profile.Email)
+	}
+
+	return gm
+}
+
+// userGroups returns the named groups each user is a member of keyed by
+// username. Membership comes from scim when it is the department source and
+// okta otherwise, regardless of the department filter.
+func (c *Client) userGroups(names []string) map[string][]string {
+	ug := make(map[string][]string)
+	if len(names) == 0 {
+		return ug
+	}
+
+	var gm map[string][]string
+	switch c.source {
+	case sourceSCIM:
+		gm = c.scim.GroupMembers()
+	case sourceMDM:
+		// there is no directory to hold the groups
+		return ug
+	default:
+		exprs := make([]string, 0, len(names))
+		for _, name := range names {
+			exprs = append(exprs, regexp.QuoteMeta(name))
+		}
+		filter := &okta.GroupFilter{
+			Include: []*regexp.Regexp{regexp.MustCompile("^(?:" + strings.Join(exprs, "|") + ")$")},
+		}
+
+		oktaGroups, err := c.okta.ListGroups(nil)
+		if err != nil {
+			c.log.Info().AnErr("error", err).Msg("failed to get okta groups")
+			return ug
+		}
+		gm = oktaGroups.GetMembers(c.okta, filter)
+	}
+
+	for group, members := range gm {
+		if !helpers.Contains(names, group) {
+			continue
+		}
+		for _, member := range members {
+			user := username(member)
+			ug[user] = append(ug[user], group)
+		}
+	}
+
+	return ug
+}
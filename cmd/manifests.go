@@ -2,94 +2,313 @@ package cmd
 
 import (
 	"errors"
-	"fmt"
 	"os"
-	"strings"
+	"sort"
+	"time"
 
 	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/pkg/naming"
+	"github.com/johnmikee/manifester/rules"
 )
 
-func (c *Client) createDeptManifest(dept string) error {
-	deptFile := fmt.Sprintf("%s/includes/%s", c.directory, dept)
-	if _, err := os.Stat(deptFile); err == nil {
-		c.log.Debug().Str("department", dept).Msg("department manifest exists")
+// validateIncludes checks the configured include manifests and templates
+// are inside the manifest directory.
+func validateIncludes(dir string, includes []string) error {
+	for _, include := range includes {
+		if _, err := naming.Path(dir, include); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createIncludeManifest creates the include manifest from the template if it
+// does not exist. include and template are relative to the manifest directory.
+func (c *Client) createIncludeManifest(include, template string) error {
+	file, err := naming.Path(c.directory, include)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		c.log.Debug().Str("include", include).Msg("include manifest exists")
 	} else if errors.Is(err, os.ErrNotExist) {
 		// does not exist - create
-		c.log.Debug().Str("department", dept).Msg("creating department manifest")
+		c.log.Debug().Str("include", include).Msg("creating include manifest")
 
-		err := c.copyGroupManifest(c.directory + "/includes/" + dept)
+		err := c.copyManifestTemplate(template, file)
 		if err != nil {
-			c.log.Info().AnErr("error", err).Str("department", dept).Msg("failed to copy manifest template")
+			c.log.Info().AnErr("error", err).Str("include", include).Msg("failed to copy manifest template")
 			return err
 		}
 	} else {
-		c.log.Debug().Str("file", deptFile).Msg("schrodinger says file may or may not exist.")
+		c.log.Debug().Str("file", file).Msg("schrodinger says file may or may not exist.")
 	}
 
 	return nil
 }
 
-func (c *Client) manifests() error {
-	manifestMachines, err := c.getDevices()
-	if err != nil {
-		c.log.Info().AnErr("error", err).Msg("failed to get devices")
-		return err
+func (c *Client) manifests(manifestMachines []MachineInfo) error {
+	// drop the rules and overrides that have expired
+	now := time.Now()
+	c.report.expire("rule", c.rules.Prune(now)...)
+	c.report.expire("override", c.overrides.Prune(now)...)
+
+	// create the dept manifests
+	departments := c.departmentManifest()
+
+	// create a manifest for each machine from the rules and departments
+	c.machineManifests(manifestMachines, departments)
+
+	c.report.log(c.log)
+
+	return nil
+}
+
+// department is a group of users that share an include manifest.
+type department struct {
+	name    string   // department name from the source
+	include string   // include manifest path, e.g. includes/eng
+	members []string // member emails
+}
+
+func (c *Client) machineManifests(manifestMachines []MachineInfo, departments []department) {
+	// invert the departments for lookup by username
+	userDepts := make(map[string][]department)
+	for _, d := range departments {
+		for _, member := range d.members {
+			user := username(member)
+			userDepts[user] = append(userDepts[user], d)
+		}
 	}
 
-	// create a manifest for each machine and a map for quick lookup later
-	machineMap := c.machineManifests(manifestMachines)
+	// membership of the catalog and ring groups
+	groups := c.userGroups(append(c.catalogs.GroupNames(), c.rings.GroupNames()...))
 
-	// create the dept manifests
-	groups := c.departmentManifest()
-
-	// add the departments to the user manifests
-	for group, members := range groups {
-		for _, member := range members {
-			serial, ok := machineMap[strings.Split(member, "@")[0]]
-			if ok {
-				err = addDeptToManifest(
-					&UpdateInfo{
-						directory:  c.directory,
-						department: group,
-						serial:     serial,
-						user:       member,
-					},
-				)
-				if err != nil {
-					c.log.Info().AnErr("error", err).Str("serial", serial).Str("group", group).Msg("failed to add dept to manifest")
-					continue
-				}
+	offboarded := c.offboardedUsers()
+
+	writer := c.writer()
+
+	now := time.Now()
+	current := c.currentManifests()
+	for _, v := range manifestMachines {
+		if !c.supportedPlatform(v.Device.Platform) {
+			c.report.ignored++
+			continue
+		}
+		if helpers.Contains(c.exclusions, v.Serial) {
+			c.report.skipped++
+			continue
+		}
+		if reason := c.inactive.reason(&v.Device, now); reason != "" {
+			c.inactiveDevice(&v, reason, current)
+			continue
+		}
+		c.restore(v.Serial)
+		if helpers.Contains(current, v.Serial) {
+			c.report.skipped++
+			continue
+		}
+
+		c.classify(&v)
+
+		user := username(v.Username)
+		manifest := c.deviceManifest(&v, userDepts[user], groups[user])
+
+		var err error
+		if status, ok := offboarded[user]; ok && user != "" {
+			c.offboard(manifest, status)
+			c.report.offboard(v.Serial, v.Username, status, c.offboarding.Actions)
+			if c.offboarding.has(offboardQuarantine) {
+				err = c.quarantine(v.Serial, manifest)
+			} else {
+				err = c.writeManifest(v.Serial, manifest)
 			}
+		} else {
+			c.unquarantine(v.Serial)
+			err = c.writeManifest(v.Serial, manifest)
 		}
+		if err != nil {
+			c.log.Info().AnErr("error", err).Str("serial", v.Serial).Msg("failed to write manifest")
+			c.report.failed++
+			continue
+		}
+		c.report.write(string(v.Device.Source))
+		c.writeBackDevice(writer, &v, userDepts[user])
 	}
-
-	return nil
 }
 
-func (c *Client) machineManifests(manifestMachines []MachineInfo) map[string]string {
-	machineMap := make(map[string]string)
-	for _, v := range manifestMachines {
-		if !helpers.Contains(c.currentManifests(), v.Serial) {
-			if !helpers.Contains(c.exclusions, v.Serial) {
-				if _, err := os.Stat(v.Serial); os.IsNotExist(err) {
-					c.copyTemplate(v.Serial, v.Username)
-				}
+// deviceManifest evaluates the rules for the device, adds the include
+// manifest of each department the assigned user belongs to and assigns
+// catalogs from the catalog policy for the groups the user is a member of.
+func (c *Client) deviceManifest(m *MachineInfo, depts []department, groups []string) *Manifest {
+	facts := c.facts(m, depts)
+	res := c.rules.Evaluate(facts)
+	c.log.Trace().Str("serial", m.Serial).Strs("rules", res.Matched).Msg("evaluated rules")
+	for _, e := range res.Errors {
+		c.log.Info().Str("serial", m.Serial).Str("error", e).Msg("failed to evaluate rule")
+	}
+
+	manifest := &Manifest{
+		Catalogs:          res.Catalogs,
+		IncludedManifests: res.IncludedManifests,
+		ManagedInstalls:   res.ManagedInstalls,
+		OptionalInstalls:  res.OptionalInstalls,
+		Metadata:          &Metadata{CatalogsRule: res.CatalogsRule, UserSource: m.UserSource},
+	}
+	manifest.Metadata.MDM = string(m.Device.Source)
+	if m.Class != nil {
+		manifest.include(m.Class.Include)
+		manifest.Metadata.Class = m.Class.Name
+	}
+	if name := c.displayName(facts); name != "" {
+		manifest.DisplayName = []string{name}
+	}
+	// nest the department includes in the org tree
+	var deptIncludes []string
+	for _, d := range depts {
+		deptIncludes = append(deptIncludes, d.include)
+	}
+	for _, include := range c.org.includes(facts.User.Managers, deptIncludes) {
+		manifest.include(include)
+	}
+
+	if c.sites != nil {
+		var location string
+		if m.Username != "" && c.siteAttribute() != "" {
+			location = c.people()[username(m.Username)].site
+		}
+		if location == "" {
+			location = m.Location
+		}
+		if include := c.siteInclude(m, location); include != "" {
+			manifest.include(include)
+		}
+	}
+
+	if m.Username != "" && c.needPeople() {
+		u := c.people()[username(m.Username)]
+		ok, err := c.onboarding.onboarding(u.startDate, time.Now())
+		if err != nil {
+			c.log.Debug().AnErr("error", err).Str("user", m.Username).Msg("failed to parse start date")
+		}
+		if ok {
+			manifest.include(c.onboarding.Include)
+		}
+	}
+
+	// a catalog group beats the ring, the ring beats the catalog default
+	ringCatalogs := false
+	if ring := c.rings.Assign(m.Serial, groups); ring != nil {
+		manifest.Metadata.Ring = ring.Name
+		if ring.Include != "" {
+			manifest.include(ring.Include)
+		}
+		if len(ring.Catalogs) > 0 {
+			manifest.Catalogs = append([]string{}, ring.Catalogs...)
+			manifest.Metadata.CatalogsRule = "ring:" + ring.Name
+			ringCatalogs = true
+		}
+	}
+	if catalogs, source, ok := c.catalogs.Assign(groups); ok && !(ringCatalogs && source == rules.DefaultCatalogSource) {
+		manifest.Catalogs = catalogs
+		manifest.Metadata.CatalogsRule = source
+	}
+
+	// overrides are merged last so they win over everything generated
+	names, overrides := c.overrides.For(m.Serial, m.Username, time.Now())
+	if len(overrides) > 0 {
+		actions := manifest.actions()
+		for i := range overrides {
+			if overrides[i].Apply(&actions) {
+				manifest.Metadata.CatalogsRule = "override:" + names[i]
 			}
 		}
-		machineMap[v.Username] = v.Serial
+		manifest.setActions(&actions)
+		manifest.Metadata.Overrides = names
+	}
+
+	return manifest
+}
+
+// facts collects the device and user attributes the rules are matched against.
+func (c *Client) facts(m *MachineInfo, depts []department) *rules.Facts {
+	f := &rules.Facts{
+		Device: deviceFacts(m),
+		User: rules.User{
+			Username: m.Username,
+			Email:    m.Email,
+		},
+	}
+	for _, d := range depts {
+		f.User.Departments = append(f.User.Departments, d.name)
+	}
+
+	if m.Username != "" && c.needPeople() {
+		if u, ok := c.people()[username(m.Username)]; ok {
+			f.User.Department = u.department
+			f.User.Title = u.title
+			f.User.Location = u.location
+		}
+		f.User.Managers = c.managers(username(m.Username))
+	}
+	if f.User.Department == "" {
+		f.User.Department = m.Department
 	}
-	return machineMap
+	if f.User.Location == "" {
+		f.User.Location = m.Location
+	}
+
+	return f
 }
 
-func (c *Client) departmentManifest() map[string][]string {
-	groups := c.oktaGroupMembers(c.filter)
-	for group := range groups {
-		err := c.createDeptManifest(group)
+// deviceFacts returns the device attributes rules and classes match on.
+func deviceFacts(m *MachineInfo) rules.Device {
+	d := rules.Device{
+		Serial:          m.Serial,
+		Hostname:        m.Device.Hostname,
+		Model:           m.Device.Model,
+		ModelIdentifier: m.Device.ModelIdentifier,
+		OSVersion:       m.Device.OSVersion,
+		Platform:        m.Device.Platform,
+		Architecture:    m.Device.Architecture,
+		LastCheckIn:     m.Device.LastCheckIn,
+		Blueprint:       m.Device.Blueprint,
+		AssetTag:        m.Device.AssetTag,
+	}
+	if m.Class != nil {
+		d.Class = m.Class.Name
+	}
+
+	return d
+}
+
+// departmentManifest creates the include manifest for each department and
+// returns the departments sorted by name.
+func (c *Client) departmentManifest() []department {
+	var departments []department
+	created := make(map[string]bool)
+	for group, members := range c.groupMembers() {
+		include, err := c.naming.Include(group)
 		if err != nil {
-			c.log.Info().AnErr("error", err).Str("group", group).Msg("failed to create dept manifest")
+			c.log.Info().AnErr("error", err).Str("group", group).Msg("skipping department with invalid name")
 			continue
 		}
+
+		// more than one group may normalise to the same include
+		if !created[include] {
+			err = c.createIncludeManifest(include, departmentTemplate)
+			if err != nil {
+				c.log.Info().AnErr("error", err).Str("group", group).Msg("failed to create dept manifest")
+				continue
+			}
+			created[include] = true
+		}
+		departments = append(departments, department{name: group, include: include, members: members})
 	}
 
-	return groups
+	sort.Slice(departments, func(i, j int) bool { return departments[i].name < departments[j].name })
+
+	return departments
 }
@@ -1,15 +1,22 @@
 package cmd
 
 import (
-	"fmt"
-	"io"
+	"encoding/json"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/mdm/abm"
+	"github.com/johnmikee/manifester/pkg/helpers"
 	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/pkg/naming"
+	"github.com/johnmikee/manifester/pkg/rings"
+	"github.com/johnmikee/manifester/rules"
+	"github.com/johnmikee/manifester/scim"
+	"howett.net/plist"
 )
 
 var log = logger.NewLogger(
@@ -21,151 +28,1079 @@ var log = logger.NewLogger(
 	},
 )
 
-func top() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+func TestRemoveEntries(t *testing.T) {
+	// Create a temporary directory for testing
+	tempDir := t.TempDir()
+
+	// Create some mock files in the directory
+	file1 := "file1.txt"
+	file2 := "file2.txt"
+	filePath1 := tempDir + "/" + file1
+	filePath2 := tempDir + "/" + file2
+	_, err := os.Create(filePath1)
+	if err != nil {
+		t.Errorf("Failed to create test file: %v", err)
+	}
+	_, err = os.Create(filePath2)
+	if err != nil {
+		t.Errorf("Failed to create test file: %v", err)
+	}
+
+	// Create a Client instance for testing
+	client := &Client{
+		directory:  tempDir,
+		exclusions: []string{file1},
+		log:        &log,
+	}
 
-	// Capture the output of the command
-	output, err := cmd.Output()
+	// Test the removeEntries function
+	err = client.removeEntries()
 	if err != nil {
-		return "", err
+		t.Errorf("removeEntries returned an error: %v", err)
 	}
 
-	// Convert the output to a string and trim any leading/trailing whitespace
-	topLevelDir := strings.TrimSpace(string(output))
+	// Check if the excluded file is still present
+	_, err = os.Stat(filePath1)
+	if os.IsNotExist(err) {
+		t.Errorf("Excluded file was unexpectedly removed")
+	}
 
-	return topLevelDir, nil
+	// Check if the non-excluded file was removed
+	_, err = os.Stat(filePath2)
+	if !os.IsNotExist(err) {
+		t.Errorf("Non-excluded file was not removed")
+	}
 }
 
-func TestCreateDeptManifest(t *testing.T) {
-	// Create a temporary directory for testing
+func TestMachineManifests(t *testing.T) {
 	tempDir := t.TempDir()
 
-	// Create a Client instance for testing
 	client := &Client{
-		directory: tempDir + "/manifests",
-		log:       &log,
+		directory:  tempDir,
+		exclusions: []string{"EXCLUDED"},
+		rules:      rules.Default(),
+		log:        &log,
 	}
 
-	// Test when department manifest does not exist
-	dept := "TestDept"
-	// copy the manifests to the temp directory
-	top, _ := top()
-	err := copyDir(top+"/munki_repo/manifests", client.directory)
-	if err != nil {
-		t.Errorf("Failed to copy manifests to temp directory: %v", err)
+	machines := []MachineInfo{
+		{Serial: "C02ABC123", Username: "jane", Email: "jane@example.com"},
+		{Serial: "C02XYZ789"},
+		{Serial: "EXCLUDED", Username: "joe"},
+	}
+	departments := []department{
+		{name: "dept-eng", include: "includes/dept-eng", members: []string{"Jane@example.com"}},
 	}
 
-	err = client.createDeptManifest(dept)
-	if err != nil {
-		t.Errorf("createDeptManifest returned an error: %v", err)
+	client.machineManifests(machines, departments)
+
+	read := func(serial string) *Manifest {
+		data, err := os.ReadFile(filepath.Join(tempDir, serial))
+		if err != nil {
+			t.Fatalf("Failed to read manifest: %v", err)
+		}
+		var m Manifest
+		if _, err := plist.Unmarshal(data, &m); err != nil {
+			t.Fatalf("Failed to unmarshal manifest: %v", err)
+		}
+		return &m
 	}
 
-	// Check if the department manifest file exists
-	deptFile := fmt.Sprintf("%s/includes/%s", client.directory, dept)
-	_, err = os.Stat(deptFile)
-	if os.IsNotExist(err) {
-		t.Errorf("Department manifest file does not exist")
+	t.Run("assigned user", func(t *testing.T) {
+		m := read("C02ABC123")
+		expected := "includes/apple_apps,includes/common_base,includes/optional_apps,includes/security,includes/dept-eng"
+		if got := strings.Join(m.IncludedManifests, ","); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+		if len(m.DisplayName) != 1 || m.DisplayName[0] != "jane" {
+			t.Errorf("Expected display name jane, got %v", m.DisplayName)
+		}
+	})
+
+	t.Run("no user", func(t *testing.T) {
+		m := read("C02XYZ789")
+		if helpers.Contains(m.IncludedManifests, "includes/security") {
+			t.Errorf("Expected no security include, got %v", m.IncludedManifests)
+		}
+		if len(m.Catalogs) != 1 || m.Catalogs[0] != "production" {
+			t.Errorf("Expected production catalog, got %v", m.Catalogs)
+		}
+	})
+
+	t.Run("excluded", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(tempDir, "EXCLUDED")); !os.IsNotExist(err) {
+			t.Errorf("Expected no manifest for excluded serial")
+		}
+	})
+}
+
+func TestDeviceManifestCatalogs(t *testing.T) {
+	client := &Client{
+		rules: rules.Default(),
+		catalogs: &rules.CatalogPolicy{
+			Groups:  []rules.CatalogGroup{{Group: "it-canary", Catalogs: []string{"testing", "production"}}},
+			Default: []string{"production"},
+		},
+		log: &log,
 	}
+	machine := &MachineInfo{Serial: "C02ABC123", Username: "jane"}
 
-	// Test when department manifest already exists
-	err = client.createDeptManifest(dept)
-	if err != nil {
-		t.Errorf("createDeptManifest returned an error for an existing department manifest: %v", err)
+	tests := []struct {
+		name     string
+		groups   []string
+		expected string
+		rule     string
+	}{
+		{"canary", []string{"it-canary"}, "testing,production", "group:it-canary"},
+		{"everyone else", nil, "production", rules.DefaultCatalogSource},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := client.deviceManifest(machine, nil, tt.groups)
+			if got := strings.Join(m.Catalogs, ","); got != tt.expected {
+				t.Errorf("Expected catalogs %s, got %s", tt.expected, got)
+			}
+			if m.Metadata.CatalogsRule != tt.rule {
+				t.Errorf("Expected catalogs_rule %s, got %s", tt.rule, m.Metadata.CatalogsRule)
+			}
+		})
+	}
+
+	t.Run("no policy", func(t *testing.T) {
+		client.catalogs = nil
+		m := client.deviceManifest(machine, nil, []string{"it-canary"})
+		if m.Metadata.CatalogsRule != "base" {
+			t.Errorf("Expected catalogs_rule base, got %s", m.Metadata.CatalogsRule)
+		}
+	})
 }
 
-func copyDir(src, dest string) error {
-	// Create the destination directory if it doesn't exist
-	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
-		return err
+func TestDeviceManifestRings(t *testing.T) {
+	client := &Client{
+		rules: rules.Default(),
+		catalogs: &rules.CatalogPolicy{
+			Groups:  []rules.CatalogGroup{{Group: "it-canary", Catalogs: []string{"testing", "production"}}},
+			Default: []string{"production"},
+		},
+		rings: &rings.Config{
+			Rings: []rings.Ring{
+				{Name: "canary", Percent: 5, Include: "includes/ring_canary", Serials: []string{"C02CANARY"}},
+				{Name: "early", Percent: 25, Catalogs: []string{"early", "production"}, Serials: []string{"C02EARLY"}},
+			},
+		},
+		log: &log,
 	}
 
-	// Get a list of files and subdirectories in the source directory
-	entries, err := os.ReadDir(src)
+	t.Run("include", func(t *testing.T) {
+		m := client.deviceManifest(&MachineInfo{Serial: "C02CANARY"}, nil, nil)
+		if !helpers.Contains(m.IncludedManifests, "includes/ring_canary") || m.Metadata.Ring != "canary" {
+			t.Errorf("Expected ring canary include, got %v in ring %s", m.IncludedManifests, m.Metadata.Ring)
+		}
+		if m.Metadata.CatalogsRule != rules.DefaultCatalogSource {
+			t.Errorf("Expected catalogs from the default, got %s", m.Metadata.CatalogsRule)
+		}
+	})
+
+	t.Run("catalogs beat the default", func(t *testing.T) {
+		m := client.deviceManifest(&MachineInfo{Serial: "C02EARLY"}, nil, nil)
+		if strings.Join(m.Catalogs, ",") != "early,production" || m.Metadata.CatalogsRule != "ring:early" {
+			t.Errorf("Expected ring catalogs, got %v from %s", m.Catalogs, m.Metadata.CatalogsRule)
+		}
+	})
+
+	t.Run("catalog group beats the ring", func(t *testing.T) {
+		m := client.deviceManifest(&MachineInfo{Serial: "C02EARLY"}, nil, []string{"it-canary"})
+		if m.Metadata.CatalogsRule != "group:it-canary" {
+			t.Errorf("Expected catalogs from it-canary, got %s", m.Metadata.CatalogsRule)
+		}
+	})
+}
+
+func TestDeviceManifestOverrides(t *testing.T) {
+	client := &Client{
+		rules: rules.Default(),
+		overrides: &rules.Overrides{
+			Serials: map[string]rules.OverrideList{
+				"C02ABC123": {
+					{
+						Actions: rules.Actions{Catalogs: []string{"testing", "production"}},
+						Remove:  rules.Actions{IncludedManifests: []string{"includes/security"}},
+					},
+				},
+			},
+		},
+		log: &log,
+	}
+
+	m := client.deviceManifest(&MachineInfo{Serial: "C02ABC123", Username: "jane"}, nil, nil)
+	if helpers.Contains(m.IncludedManifests, "includes/security") {
+		t.Errorf("Expected includes/security to be removed, got %v", m.IncludedManifests)
+	}
+	if m.Metadata.CatalogsRule != "override:serial:C02ABC123" {
+		t.Errorf("Expected catalogs from the override, got %s", m.Metadata.CatalogsRule)
+	}
+	if strings.Join(m.Metadata.Overrides, ",") != "serial:C02ABC123" {
+		t.Errorf("Expected the override to be recorded, got %v", m.Metadata.Overrides)
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	var opts Opts
+	err := json.Unmarshal([]byte(`{"exclusions": [
+		"C02ABC123",
+		{"serial": "C02TEMP01", "expires": "2024-06-01T00:00:00Z"}
+	]}`), &opts)
 	if err != nil {
-		return err
+		t.Fatalf("Failed to unmarshal exclusions: %v", err)
+	}
+
+	active, expired := activeExclusions(opts.Exclusions, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	if strings.Join(active, ",") != "C02ABC123,C02TEMP01" || len(expired) != 0 {
+		t.Errorf("Expected both exclusions to be active, got %v and expired %v", active, expired)
+	}
+
+	active, expired = activeExclusions(opts.Exclusions, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if strings.Join(active, ",") != "C02ABC123" || strings.Join(expired, ",") != "C02TEMP01" {
+		t.Errorf("Expected C02TEMP01 to expire, got %v and expired %v", active, expired)
+	}
+}
+
+func TestOnboarding(t *testing.T) {
+	o := &OnboardingOpts{Days: 7}
+	o.setDefaults()
+
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		startDate string
+		expected  bool
+	}{
+		{"before start", "2024-06-17", true},
+		{"first week", "2024-06-05", true},
+		{"timestamp", "2024-06-05T09:00:00Z", true},
+		{"after period", "2024-06-03", false},
+		{"no start date", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := o.onboarding(tt.startDate, now)
+			if err != nil {
+				t.Fatalf("onboarding returned an error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("manifest", func(t *testing.T) {
+		client := &Client{
+			rules:      rules.Default(),
+			onboarding: o,
+			directoryUsers: map[string]directoryUser{
+				"jane": {email: "jane@example.com", startDate: time.Now().Format("2006-01-02")},
+				"joe":  {email: "joe@example.com", startDate: "2020-01-06"},
+			},
+			log: &log,
+		}
+
+		m := client.deviceManifest(&MachineInfo{Serial: "C02ABC123", Username: "jane"}, nil, nil)
+		if !helpers.Contains(m.IncludedManifests, "includes/onboarding") {
+			t.Errorf("Expected includes/onboarding for a new hire, got %v", m.IncludedManifests)
+		}
+		m = client.deviceManifest(&MachineInfo{Serial: "C02XYZ789", Username: "joe"}, nil, nil)
+		if helpers.Contains(m.IncludedManifests, "includes/onboarding") {
+			t.Errorf("Expected no includes/onboarding after the onboarding period, got %v", m.IncludedManifests)
+		}
+	})
+}
+
+func TestOffboarding(t *testing.T) {
+	tempDir := t.TempDir()
+	opts := &OffboardingOpts{
+		Actions:           []string{offboardInclude, offboardUninstall, offboardQuarantine},
+		ManagedUninstalls: []string{"AdobeCC"},
+	}
+	opts.setDefaults()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+
+	client := &Client{
+		directory:   tempDir,
+		rules:       rules.Default(),
+		offboarding: opts,
+		log:         &log,
+	}
+
+	m := client.deviceManifest(&MachineInfo{Serial: "C02ABC123", Username: "jane"}, nil, nil)
+	m.ManagedInstalls = []string{"AdobeCC", "Slack"}
+	client.offboard(m, "SUSPENDED")
+
+	if strings.Join(m.IncludedManifests, ",") != "includes/offboarding" {
+		t.Errorf("Expected only includes/offboarding, got %v", m.IncludedManifests)
+	}
+	if strings.Join(m.ManagedUninstalls, ",") != "AdobeCC" || len(m.ManagedInstalls) != 0 {
+		t.Errorf("Expected AdobeCC to be uninstalled, got %v and %v", m.ManagedUninstalls, m.ManagedInstalls)
+	}
+	if m.Metadata.Offboarded != "SUSPENDED" {
+		t.Errorf("Expected offboarded SUSPENDED, got %s", m.Metadata.Offboarded)
 	}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		destPath := filepath.Join(dest, entry.Name())
+	if err := client.quarantine("C02ABC123", m); err != nil {
+		t.Fatalf("quarantine returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "quarantine", "C02ABC123")); err != nil {
+		t.Errorf("Expected the manifest to be quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "C02ABC123")); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest in the manifest directory")
+	}
 
-		if entry.IsDir() {
-			// Recursively copy subdirectories
-			if err := copyDir(srcPath, destPath); err != nil {
-				return err
+	client.unquarantine("C02ABC123")
+	if _, err := os.Stat(filepath.Join(tempDir, "quarantine", "C02ABC123")); !os.IsNotExist(err) {
+		t.Errorf("Expected the quarantined manifest to be removed")
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, o := range []*OffboardingOpts{{}, {Actions: []string{"wipe"}}, {Actions: []string{offboardUninstall}}} {
+			if err := o.Validate(); err == nil {
+				t.Errorf("Expected %v to be invalid", o.Actions)
 			}
-		} else {
-			// Copy files
-			if err := copyFile(srcPath, destPath); err != nil {
-				return err
+		}
+	})
+}
+
+func TestOrgTree(t *testing.T) {
+	org := &OrgOpts{
+		Nodes: []OrgNode{
+			{Manager: "lead@example.com", Level: "team", Include: "includes/team_vision"},
+			{Manager: "vp@example.com", Level: "org", Include: "includes/org_research"},
+			{Manager: "ceo@example.com", Level: "org", Include: "includes/org_all"},
+		},
+	}
+	org.setDefaults()
+	if err := org.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+
+	client := &Client{
+		rules: &rules.Ruleset{
+			Rules: []rules.Rule{
+				{
+					Name:    "research",
+					Match:   rules.Match{ReportsTo: []string{"vp"}},
+					Actions: rules.Actions{IncludedManifests: []string{"includes/research_tools"}},
+				},
+			},
+		},
+		org: org,
+		directoryUsers: map[string]directoryUser{
+			"ceo":  {id: "00u1", email: "ceo@example.com"},
+			"vp":   {id: "00u2", email: "vp@example.com", manager: "00u1"},
+			"lead": {id: "00u3", email: "lead@example.com", employeeNo: "1003", manager: "VP@example.com"},
+			"jane": {id: "00u4", email: "jane@example.com", manager: "1003"},
+			"loop": {id: "00u5", email: "loop@example.com", manager: "00u5"},
+		},
+		log: &log,
+	}
+
+	if chain := strings.Join(client.managers("jane"), ","); chain != "lead@example.com,vp@example.com,ceo@example.com" {
+		t.Errorf("Expected the chain up to the ceo, got %s", chain)
+	}
+	if chain := client.managers("loop"); len(chain) != 0 {
+		t.Errorf("Expected a self managed user to have no chain, got %v", chain)
+	}
+
+	depts := []department{{name: "dept-ml", include: "includes/dept-ml"}}
+	m := client.deviceManifest(&MachineInfo{Serial: "C02ABC123", Username: "jane"}, depts, nil)
+	expected := "includes/research_tools,includes/org_all,includes/org_research,includes/dept-ml,includes/team_vision"
+	if got := strings.Join(m.IncludedManifests, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestSites(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "includes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, siteTemplate), []byte("site template"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sites := &SiteOpts{
+		Blueprints: map[string]string{"London Macs": "London"},
+		Attribute:  "officeLocation",
+		Map:        map[string]string{"NYC-5th-Ave": "New York"},
+	}
+	sites.setDefaults()
+
+	client := &Client{
+		directory: tempDir,
+		rules:     rules.Default(),
+		sites:     sites,
+		directoryUsers: map[string]directoryUser{
+			"jane": {email: "jane@example.com", site: "NYC-5th-Ave"},
+			"joe":  {email: "joe@example.com", site: "Berlin"},
+		},
+		log: &log,
+	}
+
+	tests := []struct {
+		name     string
+		machine  MachineInfo
+		expected string
+	}{
+		{"blueprint", MachineInfo{Serial: "1", Username: "jane", Device: mdm.Device{Blueprint: "London Macs"}}, "includes/site_london"},
+		{"mapped location", MachineInfo{Serial: "2", Username: "jane"}, "includes/site_new_york"},
+		{"location", MachineInfo{Serial: "3", Username: "joe"}, "includes/site_berlin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := client.deviceManifest(&tt.machine, nil, nil)
+			if !helpers.Contains(m.IncludedManifests, tt.expected) {
+				t.Errorf("Expected %s, got %v", tt.expected, m.IncludedManifests)
+			}
+			data, err := os.ReadFile(filepath.Join(tempDir, tt.expected))
+			if err != nil || string(data) != "site template" {
+				t.Errorf("Expected %s to be created from the site template: %v", tt.expected, err)
+			}
+		})
+	}
+
+	t.Run("no site", func(t *testing.T) {
+		m := client.deviceManifest(&MachineInfo{Serial: "4"}, nil, nil)
+		for _, include := range m.IncludedManifests {
+			if strings.HasPrefix(include, "includes/site_") {
+				t.Errorf("Expected no site include, got %s", include)
 			}
 		}
+	})
+
+	t.Run("naming policy", func(t *testing.T) {
+		client.naming = &naming.Policy{Rename: map[string]string{"site_new_york": "site_nyc"}}
+		defer func() { client.naming = nil }()
+		m := client.deviceManifest(&MachineInfo{Serial: "5", Username: "jane"}, nil, nil)
+		if !helpers.Contains(m.IncludedManifests, "includes/site_nyc") {
+			t.Errorf("Expected the renamed site, got %v", m.IncludedManifests)
+		}
+	})
+}
+
+func TestIncludes(t *testing.T) {
+	opts := &Opts{
+		Onboarding:    &OnboardingOpts{},
+		Offboarding:   &OffboardingOpts{},
+		Sites:         &SiteOpts{},
+		DeviceClasses: rules.Classes{{Name: "Lab Macs"}},
 	}
+	opts.Onboarding.setDefaults()
+	opts.Offboarding.setDefaults()
+	opts.Sites.setDefaults()
 
-	return nil
+	policy := &naming.Policy{Case: "lower", Whitespace: "_", Directory: "includes/classes"}
+	if err := classIncludes(opts.DeviceClasses, policy); err != nil {
+		t.Fatalf("classIncludes returned an error: %v", err)
+	}
+	if opts.DeviceClasses[0].Include != "includes/classes/lab_macs_base" {
+		t.Errorf("Expected the class include to follow the naming policy, got %s", opts.DeviceClasses[0].Include)
+	}
+
+	if err := validateIncludes(t.TempDir(), opts.includes()); err != nil {
+		t.Errorf("Expected the default includes to be valid, got %v", err)
+	}
+
+	for _, opts := range []*Opts{
+		{Onboarding: &OnboardingOpts{Include: "../onboarding"}},
+		{Offboarding: &OffboardingOpts{Include: "includes/../../offboarding"}},
+		{Sites: &SiteOpts{Prefix: "../site_"}},
+		{Org: &OrgOpts{Nodes: []OrgNode{{Include: "/"}}}},
+		{Rings: &rings.Config{Rings: []rings.Ring{{Name: "canary", Include: "../../canary"}}}},
+		{DeviceClasses: rules.Classes{{Name: "lab", Include: "../lab"}}},
+	} {
+		if err := validateIncludes(t.TempDir(), opts.includes()); err == nil {
+			t.Errorf("Expected %v to be invalid", opts.includes())
+		}
+	}
+
+	t.Run("rules and overrides", func(t *testing.T) {
+		if err := validateIncludes(t.TempDir(), rules.Default().Includes()); err != nil {
+			t.Errorf("Expected the default rules to be valid, got %v", err)
+		}
+
+		ruleset := &rules.Ruleset{Rules: []rules.Rule{
+			{Name: "escape", Actions: rules.Actions{IncludedManifests: []string{"includes/../../escape"}}},
+		}}
+		if err := validateIncludes(t.TempDir(), ruleset.Includes()); err == nil {
+			t.Errorf("Expected %v to be invalid", ruleset.Includes())
+		}
+
+		overrides := &rules.Overrides{Serials: map[string]rules.OverrideList{
+			"C02ABC123": {{Actions: rules.Actions{IncludedManifests: []string{"../escape"}}}},
+		}}
+		if err := validateIncludes(t.TempDir(), overrides.Includes()); err == nil {
+			t.Errorf("Expected %v to be invalid", overrides.Includes())
+		}
+	})
 }
 
-func copyFile(src, dest string) error {
-	srcFile, err := os.Open(src)
+func TestDeviceAttributes(t *testing.T) {
+	display, err := displayTemplate("{{.User.Username}} - {{.Device.Model}} ({{.Device.Architecture}})")
 	if err != nil {
-		return err
+		t.Fatalf("displayTemplate returned an error: %v", err)
 	}
-	defer srcFile.Close()
 
-	destFile, err := os.Create(dest)
-	if err != nil {
-		return err
+	client := &Client{
+		rules: &rules.Ruleset{
+			Rules: []rules.Rule{
+				{
+					Name:    "intel",
+					Match:   rules.Match{Architecture: []string{mdm.X8664}},
+					Actions: rules.Actions{IncludedManifests: []string{"includes/rosetta_free_alternatives"}},
+				},
+			},
+		},
+		display: display,
+		log:     &log,
 	}
-	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		return err
+	machine := &MachineInfo{
+		Serial:   "C02ABC123",
+		Username: "jane",
+		Device:   mdm.Device{Model: "MacBook Pro (16-inch, 2019)", Architecture: mdm.X8664},
+	}
+	m := client.deviceManifest(machine, nil, nil)
+	if !helpers.Contains(m.IncludedManifests, "includes/rosetta_free_alternatives") {
+		t.Errorf("Expected the intel include, got %v", m.IncludedManifests)
+	}
+	if name := strings.Join(m.DisplayName, ""); name != "jane - MacBook Pro (16-inch, 2019) (x86_64)" {
+		t.Errorf("Expected the templated display name, got %s", name)
 	}
 
-	return destFile.Sync()
+	t.Run("plain value keeps the username", func(t *testing.T) {
+		if tmpl, err := displayTemplate("serial_number"); tmpl != nil || err != nil {
+			t.Errorf("Expected no template, got %v and %v", tmpl, err)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		if _, err := displayTemplate("{{.Device.Colour}}"); err == nil {
+			t.Errorf("Expected an error for an unknown field")
+		}
+	})
 }
 
-func TestRemoveEntries(t *testing.T) {
-	// Create a temporary directory for testing
+func TestInactiveDevices(t *testing.T) {
 	tempDir := t.TempDir()
+	inactive := &InactiveOpts{Action: inactiveArchive, Removed: true, Missing: true, StaleDays: 30}
+	inactive.setDefaults()
+	if err := inactive.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
 
-	// Create some mock files in the directory
-	file1 := "file1.txt"
-	file2 := "file2.txt"
-	filePath1 := tempDir + "/" + file1
-	filePath2 := tempDir + "/" + file2
-	_, err := os.Create(filePath1)
+	client := &Client{
+		directory: tempDir,
+		rules:     rules.Default(),
+		platforms: []string{"Mac"},
+		inactive:  inactive,
+		log:       &log,
+	}
+
+	// an existing manifest for the removed device is archived
+	if err := os.WriteFile(filepath.Join(tempDir, "REMOVED"), []byte("manifest"), 0o644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	machines := []MachineInfo{
+		{Serial: "IPHONE", Device: mdm.Device{Platform: "iPhone"}},
+		{Serial: "MAC", Device: mdm.Device{Platform: "mac", LastCheckIn: time.Now()}},
+		{Serial: "UNKNOWN"},
+		{Serial: "REMOVED", Device: mdm.Device{Platform: "Mac", Removed: true}},
+		{Serial: "MISSING", Device: mdm.Device{Platform: "Mac", Missing: true}},
+		{Serial: "STALE", Device: mdm.Device{Platform: "Mac", LastCheckIn: time.Now().AddDate(0, 0, -31)}},
+	}
+	client.machineManifests(machines, nil)
+
+	exists := func(path ...string) bool {
+		_, err := os.Stat(filepath.Join(append([]string{tempDir}, path...)...))
+		return err == nil
+	}
+
+	for serial, expected := range map[string]bool{"IPHONE": false, "MAC": true, "UNKNOWN": true, "REMOVED": false, "MISSING": false, "STALE": false} {
+		if exists(serial) != expected {
+			t.Errorf("Expected manifest for %s to exist: %v", serial, expected)
+		}
+	}
+	if !exists("archive", "REMOVED") {
+		t.Errorf("Expected the manifest of the removed device to be archived")
+	}
+	if client.report.ignored != 1 || len(client.report.inactive) != 3 {
+		t.Errorf("Expected 1 ignored and 3 inactive devices, got %d and %d", client.report.ignored, len(client.report.inactive))
+	}
+	if d := client.report.inactive[0]; d.reason != reasonRemoved || d.action != inactiveArchive {
+		t.Errorf("Expected REMOVED to be archived, got %s and %s", d.reason, d.action)
+	}
+	if d := client.report.inactive[1]; d.reason != reasonMissing || d.action != inactiveSkip {
+		t.Errorf("Expected MISSING to be skipped without a manifest, got %s and %s", d.reason, d.action)
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		client.machineManifests([]MachineInfo{{Serial: "REMOVED", Device: mdm.Device{Platform: "Mac"}}}, nil)
+		if !exists("REMOVED") || exists("archive", "REMOVED") {
+			t.Errorf("Expected the archived manifest to be dropped for a new one")
+		}
+		data, _ := os.ReadFile(filepath.Join(tempDir, "REMOVED"))
+		if string(data) == "manifest" {
+			t.Errorf("Expected a new manifest, got the archived one")
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		tempDir := t.TempDir()
+		for _, serial := range []string{"REMOVED", "ACTIVE"} {
+			if err := os.WriteFile(filepath.Join(tempDir, serial), []byte("manifest"), 0o644); err != nil {
+				t.Fatalf("Failed to write manifest: %v", err)
+			}
+		}
+		provider := &consoleMDM{machines: []mdm.MachineInfo{
+			{Device: mdm.Device{SerialNumber: "REMOVED", Platform: "Mac", Removed: true}},
+			{Device: mdm.Device{SerialNumber: "ACTIVE", Platform: "Mac"}},
+		}}
+		client := &Client{
+			directory:         tempDir,
+			source:            sourceMDM,
+			departmentSources: []string{sourceMDM},
+			rules:             rules.Default(),
+			platforms:         []string{"Mac"},
+			inactive:          inactive,
+			mdm:               provider,
+			log:               &log,
+		}
+		if err := client.run(); err != nil {
+			t.Fatalf("run returned an error: %v", err)
+		}
+
+		data, _ := os.ReadFile(filepath.Join(tempDir, "archive", "REMOVED"))
+		if string(data) != "manifest" {
+			t.Errorf("Expected the manifest of the removed device to be archived, got %q", data)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "REMOVED")); err == nil {
+			t.Errorf("Expected the manifest of the removed device to be moved")
+		}
+		data, _ = os.ReadFile(filepath.Join(tempDir, "ACTIVE"))
+		if len(data) == 0 || string(data) == "manifest" {
+			t.Errorf("Expected a new manifest for the active device, got %q", data)
+		}
+		if d := client.report.inactive; len(d) != 1 || d[0].action != inactiveArchive {
+			t.Errorf("Expected REMOVED to be archived, got %v", d)
+		}
+
+		// the next run finds the manifest in the archive
+		client.report = report{}
+		if err := client.run(); err != nil {
+			t.Fatalf("run returned an error: %v", err)
+		}
+		if d := client.report.inactive; len(d) != 1 || d[0].action != inactiveArchived {
+			t.Errorf("Expected REMOVED to be reported as already archived, got %v", d)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, o := range []*InactiveOpts{{Action: "delete"}, {Action: inactiveSkip, StaleDays: -1}} {
+			if err := o.Validate(); err == nil {
+				t.Errorf("Expected %v to be invalid", o)
+			}
+		}
+	})
+}
+
+// consoleMDM is an mdm provider reporting console users.
+type consoleMDM struct {
+	machines []mdm.MachineInfo
+	users    map[string]mdm.ConsoleUser
+	looked   []string
+}
+
+func (p *consoleMDM) Setup(mdm.Config) {}
+
+func (p *consoleMDM) ListAllDevices() ([]mdm.MachineInfo, error) {
+	return p.machines, nil
+}
+
+func (p *consoleMDM) ConsoleUsers(devices []mdm.Device) map[string]mdm.ConsoleUser {
+	for _, d := range devices {
+		p.looked = append(p.looked, d.SerialNumber)
+	}
+	return p.users
+}
+
+func TestConsoleUsers(t *testing.T) {
+	provider := &consoleMDM{
+		machines: []mdm.MachineInfo{
+			{Device: mdm.Device{SerialNumber: "ASSIGNED"}, Users: &mdm.User{Email: "joe@example.com"}},
+			{Device: mdm.Device{SerialNumber: "CONSOLE"}},
+			{Device: mdm.Device{SerialNumber: "MAPPED"}},
+			{Device: mdm.Device{SerialNumber: "ADMIN"}},
+			{Device: mdm.Device{SerialNumber: "UNKNOWN"}},
+		},
+		users: map[string]mdm.ConsoleUser{
+			"CONSOLE": {LastUser: "Jane", Accounts: []string{"admin", "jane"}},
+			"MAPPED":  {Accounts: []string{"jsmith"}},
+			"ADMIN":   {LastUser: "admin", Accounts: []string{"admin"}},
+			"UNKNOWN": {Accounts: []string{"guest"}},
+		},
+	}
+
+	client := &Client{
+		mdm:   provider,
+		rules: rules.Default(),
+		console: &ConsoleUserOpts{
+			Map:    map[string]string{"jsmith": "john@example.com"},
+			Ignore: []string{"admin"},
+		},
+		directoryUsers: map[string]directoryUser{
+			"jane": {email: "jane@example.com"},
+			"john": {email: "john@example.com"},
+		},
+		log: &log,
+	}
+
+	machines, err := client.getDevices()
 	if err != nil {
-		t.Errorf("Failed to create test file: %v", err)
+		t.Fatalf("getDevices returned an error: %v", err)
 	}
-	_, err = os.Create(filePath2)
+	if len(provider.looked) != 4 || helpers.Contains(provider.looked, "ASSIGNED") {
+		t.Errorf("Expected only unassigned devices to be looked up, got %v", provider.looked)
+	}
+
+	expected := map[string][2]string{
+		"ASSIGNED": {"joe", userSourceMDM},
+		"CONSOLE":  {"jane", "console:jane"},
+		"MAPPED":   {"john", "console:jsmith"},
+		"ADMIN":    {"", ""},
+		"UNKNOWN":  {"", ""},
+	}
+	for _, m := range machines {
+		if got := [2]string{m.Username, m.UserSource}; got != expected[m.Serial] {
+			t.Errorf("Expected %s to resolve to %v, got %v", m.Serial, expected[m.Serial], got)
+		}
+	}
+
+	m := client.deviceManifest(&machines[1], nil, nil)
+	if m.Metadata.UserSource != "console:jane" || !helpers.Contains(m.IncludedManifests, "includes/security") {
+		t.Errorf("Expected the console user to be annotated and targeted, got %s and %v", m.Metadata.UserSource, m.IncludedManifests)
+	}
+}
+
+func TestMDMDepartments(t *testing.T) {
+	t.Run("sources", func(t *testing.T) {
+		tests := []struct {
+			value    string
+			identity string
+			sources  string
+		}{
+			{"okta", sourceOkta, "okta"},
+			{"mdm", sourceMDM, "mdm"},
+			{"both", sourceOkta, "okta,mdm"},
+			{"mdm, scim", sourceSCIM, "mdm,scim"},
+		}
+		for _, tt := range tests {
+			identity, sources, err := departmentSources(tt.value)
+			if err != nil {
+				t.Fatalf("departmentSources returned an error for %s: %v", tt.value, err)
+			}
+			if identity != tt.identity || strings.Join(sources, ",") != tt.sources {
+				t.Errorf("Expected %s and %s for %s, got %s and %v", tt.identity, tt.sources, tt.value, identity, sources)
+			}
+		}
+		for _, value := range []string{"ldap", "okta,scim"} {
+			if _, _, err := departmentSources(value); err == nil {
+				t.Errorf("Expected %s to be invalid", value)
+			}
+		}
+	})
+
+	client := &Client{
+		source:            sourceSCIM,
+		departmentSources: []string{sourceSCIM, sourceMDM},
+		profile:           profileOpts{mapping: map[string]string{"Staff Laptops": ""}},
+		log:               &log,
+	}
+	store := filepath.Join(t.TempDir(), "scim.json")
+	data := `{
+		"users": {"1": {"id": "1", "userName": "jane@example.com", "active": true}},
+		"groups": {"2": {"id": "2", "displayName": "dept-eng", "members": [{"value": "1"}]}}
+	}`
+	if err := os.WriteFile(store, []byte(data), 0o644); err != nil {
+		t.Fatalf("Failed to write scim store: %v", err)
+	}
+	var err error
+	client.scim, err = scim.Open(store)
 	if err != nil {
-		t.Errorf("Failed to create test file: %v", err)
+		t.Fatalf("Failed to open scim store: %v", err)
+	}
+
+	machines := []MachineInfo{
+		{Serial: "C02ABC123", Email: "jane@example.com", Department: "Sales"},
+		{Serial: "C02DEF456", Email: "joe@example.com", Department: "Sales", Location: "HQ"},
+		{Serial: "C02XYZ789", Email: "ann@example.com", Department: "Staff Laptops"},
+	}
+	client.mdmDepartments = client.mdmMembers(machines)
+
+	gm := client.groupMembers()
+	if strings.Join(gm["dept-eng"], ",") != "jane@example.com" {
+		t.Errorf("Expected jane in dept-eng, got %v", gm["dept-eng"])
+	}
+	if strings.Join(gm["Sales"], ",") != "joe@example.com" {
+		t.Errorf("Expected only joe in Sales, got %v", gm["Sales"])
+	}
+	if _, ok := gm["Staff Laptops"]; ok {
+		t.Errorf("Expected the unmapped department to be skipped")
+	}
+
+	f := client.facts(&machines[1], nil)
+	if f.User.Department != "Sales" || f.User.Location != "HQ" {
+		t.Errorf("Expected the mdm department and location, got %s and %s", f.User.Department, f.User.Location)
+	}
+}
+
+func TestDeviceClasses(t *testing.T) {
+	tempDir := t.TempDir()
+	classes := rules.Classes{{Name: "lab", Hostnames: []string{"lab-*"}}}
+	if err := classIncludes(classes, nil); err != nil {
+		t.Fatalf("classIncludes returned an error: %s", err)
 	}
 
-	// Create a Client instance for testing
 	client := &Client{
-		directory:  tempDir,
-		exclusions: []string{file1},
-		log:        &log,
+		directory: tempDir,
+		rules:     rules.Default(),
+		classes:   classes,
+		log:       &log,
 	}
 
-	// Test the removeEntries function
-	err = client.removeEntries()
+	machines := []MachineInfo{
+		{Serial: "C02LAB001", Username: "jane", Email: "jane@example.com", Device: mdm.Device{Hostname: "LAB-01"}},
+		{Serial: "C02ABC123", Username: "jane", Email: "jane@example.com", Device: mdm.Device{Hostname: "janes-mbp"}},
+	}
+	departments := []department{
+		{name: "dept-eng", include: "includes/dept-eng", members: []string{"jane@example.com"}},
+	}
+	client.machineManifests(machines, departments)
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "C02LAB001"))
 	if err != nil {
-		t.Errorf("removeEntries returned an error: %v", err)
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var m Manifest
+	if _, err := plist.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Failed to unmarshal manifest: %v", err)
 	}
 
-	// Check if the excluded file is still present
-	_, err = os.Stat(filePath1)
-	if os.IsNotExist(err) {
-		t.Errorf("Excluded file was unexpectedly removed")
+	expected := "includes/apple_apps,includes/common_base,includes/optional_apps,includes/lab_base"
+	if got := strings.Join(m.IncludedManifests, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if strings.Join(m.DisplayName, "") != "lab" || m.Metadata.Class != "lab" {
+		t.Errorf("Expected the lab class as the display name, got %v and %s", m.DisplayName, m.Metadata.Class)
 	}
 
-	// Check if the non-excluded file was removed
-	_, err = os.Stat(filePath2)
-	if !os.IsNotExist(err) {
-		t.Errorf("Non-excluded file was not removed")
+	m2 := client.deviceManifest(&machines[1], departments, nil)
+	if m2.Metadata.Class != "" || !helpers.Contains(m2.IncludedManifests, "includes/dept-eng") {
+		t.Errorf("Expected a user manifest, got %v", m2.IncludedManifests)
+	}
+}
+
+func TestMultipleMDMs(t *testing.T) {
+	cfg := &Config{MDMToken: "shared", KandjiURL: "https://example.kandji.io", JamfUser: "api", JamfPass: "secret"}
+	opts := &Opts{MDMPrecedence: mdm.PrecedenceCheckIn}
+	mdms, err := mdmProviders("kandji, jamf", "test", cfg, opts, log)
+	if err != nil {
+		t.Fatalf("mdmProviders returned an error: %v", err)
+	}
+	if len(mdms) != 2 || mdms[0].Config.URL != "https://example.kandji.io" || mdms[0].Config.Token != "shared" {
+		t.Errorf("Expected kandji with its own url and the shared token, got %+v", mdms[0].Config)
+	}
+	if mdms[1].Config.User != "api" || mdms[1].Config.Password != "secret" {
+		t.Errorf("Expected the jamf credentials, got %s and %s", mdms[1].Config.User, mdms[1].Config.Password)
+	}
+	if _, err := mdmProviders("kandji,intune", "test", cfg, opts, log); err == nil {
+		t.Errorf("Expected an unknown mdm to be invalid")
+	}
+	if _, err := mdmProviders("kandji", "test", cfg, &Opts{MDMPrecedence: "newest"}, log); err == nil {
+		t.Errorf("Expected an unknown precedence to be invalid")
+	}
+
+	t.Run("abm", func(t *testing.T) {
+		opts := &Opts{MDMPrecedence: mdm.PrecedenceOrder, ABM: &ABMOpts{Servers: []string{"Kandji"}, URL: "http://localhost:8080/v1/"}}
+		if _, err := mdmProviders("kandji,abm", "test", cfg, opts, log); err == nil {
+			t.Errorf("Expected abm without a client id to be invalid")
+		}
+
+		cfg := &Config{ABMClientID: "BUSINESSAPI.client", ABMKeyID: "key-id", ABMPrivateKey: "key"}
+		mdms, err := mdmProviders("kandji,abm", "test", cfg, opts, log)
+		if err != nil {
+			t.Fatalf("mdmProviders returned an error: %v", err)
+		}
+		ac, ok := mdms[1].Config.ProviderSpecificConfig.(*abm.Config)
+		if !ok || ac.ClientID != "BUSINESSAPI.client" || string(ac.PrivateKey) != "key" || ac.Servers[0] != "Kandji" {
+			t.Errorf("Unexpected abm config %+v", mdms[1].Config.ProviderSpecificConfig)
+		}
+		if mdms[1].Config.URL != "http://localhost:8080/v1/" {
+			t.Errorf("Expected the abm url, got %s", mdms[1].Config.URL)
+		}
+	})
+
+	tempDir := t.TempDir()
+	client := &Client{
+		directory: tempDir,
+		rules:     rules.Default(),
+		log:       &log,
+	}
+	client.machineManifests([]MachineInfo{
+		{Serial: "C02ABC123", Device: mdm.Device{Source: mdm.Kandji}},
+		{Serial: "C02XYZ789", Device: mdm.Device{Source: mdm.Jamf}},
+		{Serial: "C02DEF456", Device: mdm.Device{Source: mdm.Jamf}},
+	}, nil)
+
+	if client.report.sources["kandji"] != 1 || client.report.sources["jamf"] != 2 {
+		t.Errorf("Expected 1 kandji and 2 jamf manifests, got %v", client.report.sources)
+	}
+	m := client.deviceManifest(&MachineInfo{Serial: "C02ABC123", Device: mdm.Device{Source: mdm.Kandji}}, nil, nil)
+	if m.Metadata.MDM != "kandji" {
+		t.Errorf("Expected the mdm to be recorded, got %s", m.Metadata.MDM)
+	}
+}
+
+// writerMDM is an mdm provider recording write backs.
+type writerMDM struct {
+	consoleMDM
+	written map[string]string
+}
+
+func (p *writerMDM) WriteBack(d mdm.Device, a mdm.Assignment) error {
+	if d.Source == mdm.ABM {
+		return mdm.ErrUnsupported
+	}
+	p.written[d.SerialNumber] = a.String()
+	return nil
+}
+
+func TestWriteBack(t *testing.T) {
+	provider := &writerMDM{written: make(map[string]string)}
+	opts := &WriteBackOpts{}
+	opts.setDefaults()
+
+	machines := []MachineInfo{
+		{Serial: "C02ABC123", Username: "jane", Email: "jane@example.com", Device: mdm.Device{SerialNumber: "C02ABC123"}},
+		{Serial: "C02XYZ789", Device: mdm.Device{SerialNumber: "C02XYZ789"}},
+		{Serial: "C02ABM001", Device: mdm.Device{SerialNumber: "C02ABM001", Source: mdm.ABM}},
+	}
+	departments := []department{
+		{name: "dept-eng", include: "includes/dept-eng", members: []string{"jane@example.com"}},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		client := &Client{directory: t.TempDir(), rules: rules.Default(), mdm: provider, writeBack: opts, dryRun: true, log: &log}
+		client.machineManifests(machines, departments)
+		if len(provider.written) != 0 || client.report.writtenBack != 0 {
+			t.Errorf("Expected nothing to be written back, got %v", provider.written)
+		}
+	})
+
+	client := &Client{directory: t.TempDir(), rules: rules.Default(), mdm: provider, writeBack: opts, log: &log}
+	client.machineManifests(machines, departments)
+	if provider.written["C02ABC123"] != "C02ABC123 (dept-eng)" || provider.written["C02XYZ789"] != "C02XYZ789" {
+		t.Errorf("Unexpected write backs %v", provider.written)
 	}
+	if client.report.writtenBack != 2 {
+		t.Errorf("Expected 2 write backs, got %d", client.report.writtenBack)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	opts := &ProfileOpts{Prefs: map[string]interface{}{
+		"SoftwareRepoURL":          "https://munki.example.com/repo",
+		"DaysBetweenNotifications": float64(1),
+		"AdditionalHttpHeaders":    []interface{}{"X-Device: {{.Device.Hostname}}"},
+	}}
+	opts.setDefaults()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	payload := func(data []byte) map[string]interface{} {
+		var p struct {
+			PayloadUUID    string
+			PayloadContent []map[string]interface{}
+		}
+		if _, err := plist.Unmarshal(data, &p); err != nil {
+			t.Fatalf("Error unmarshalling profile: %s", err)
+		}
+		if len(p.PayloadContent) != 1 {
+			t.Fatalf("Expected 1 payload, got %d", len(p.PayloadContent))
+		}
+		return p.PayloadContent[0]
+	}
+
+	data, err := opts.fleetProfile(mdm.Kandji)
+	if err != nil {
+		t.Fatalf("fleetProfile returned an error: %s", err)
+	}
+	p := payload(data)
+	if p["PayloadType"] != munkiDomain || p["ClientIdentifier"] != "$SERIAL_NUMBER" || p["SoftwareRepoURL"] != "https://munki.example.com/repo" {
+		t.Errorf("Unexpected payload %v", p)
+	}
+	if p["DaysBetweenNotifications"] != uint64(1) {
+		t.Errorf("Expected an integer, got %T", p["DaysBetweenNotifications"])
+	}
+	again, _ := opts.fleetProfile(mdm.Kandji)
+	if string(again) != string(data) {
+		t.Errorf("Expected the profile to be generated the same way every time")
+	}
+	if _, err := opts.fleetProfile(mdm.ABM); err == nil {
+		t.Errorf("Expected an error for an mdm without variables")
+	}
+
+	t.Run("per device", func(t *testing.T) {
+		dir := t.TempDir()
+		client := &Client{exclusions: []string{"C02XYZ789"}, platforms: []string{"Mac"}, log: &log}
+		machines := []MachineInfo{
+			{Serial: "C02ABC123", Device: mdm.Device{SerialNumber: "C02ABC123", Hostname: "janes-mac", Platform: "Mac"}},
+			{Serial: "C02XYZ789", Device: mdm.Device{SerialNumber: "C02XYZ789", Platform: "Mac"}},
+			{Serial: "DMPIPAD01", Device: mdm.Device{SerialNumber: "DMPIPAD01", Platform: "iPad"}},
+		}
+		written, err := client.deviceProfiles(opts, dir, machines)
+		if err != nil || written != 1 {
+			t.Fatalf("Expected 1 profile, got %d: %v", written, err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "C02ABC123.mobileconfig"))
+		if err != nil {
+			t.Fatalf("Error reading profile: %s", err)
+		}
+		p := payload(data)
+		headers, _ := p["AdditionalHttpHeaders"].([]interface{})
+		if p["ClientIdentifier"] != "C02ABC123" || len(headers) != 1 || headers[0] != "X-Device: janes-mac" {
+			t.Errorf("Unexpected payload %v", p)
+		}
+	})
+
+	t.Run("profile client", func(t *testing.T) {
+		provider := &consoleMDM{
+			machines: []mdm.MachineInfo{{Device: mdm.Device{SerialNumber: "C02ABC123"}}},
+			users:    map[string]mdm.ConsoleUser{"C02ABC123": {LastUser: "jane"}},
+		}
+		client := profileClient(&Opts{ConsoleUsers: &ConsoleUserOpts{}}, provider, &log)
+		machines, err := client.getDevices()
+		if err != nil || len(machines) != 1 || machines[0].Username != "" {
+			t.Errorf("Expected the device without a console user, got %v: %v", machines, err)
+		}
+	})
+
+	t.Run("repo url", func(t *testing.T) {
+		opts := &ProfileOpts{}
+		opts.setDefaults()
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Expected an error without SoftwareRepoURL")
+		}
+	})
 }
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnmikee/manifester/okta"
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// offboarding actions
+const (
+	offboardInclude    = "include"
+	offboardUninstall  = "uninstall"
+	offboardQuarantine = "quarantine"
+)
+
+// OffboardingOpts control the manifests of devices still assigned to users
+// that have been deactivated in the identity provider.
+type OffboardingOpts struct {
+	// Actions are any of include, uninstall and quarantine.
+	Actions []string `json:"actions"`
+	// Include replaces the included manifests, default includes/offboarding.
+	Include string `json:"include"`
+	// ManagedUninstalls are removed from the device, e.g. licensed software.
+	ManagedUninstalls []string `json:"managed-uninstalls"`
+	// QuarantineDir receives the manifest instead of the manifest directory.
+	// Relative paths are relative to the manifest directory, default quarantine.
+	QuarantineDir string `json:"quarantine-dir"`
+	// Statuses are the okta statuses treated as offboarded, default
+	// DEPROVISIONED and SUSPENDED. Inactive SCIM users are always offboarded.
+	Statuses []string `json:"statuses"`
+}
+
+func (o *OffboardingOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if o.Include == "" {
+		o.Include = "includes/offboarding"
+	}
+	if o.QuarantineDir == "" {
+		o.QuarantineDir = "quarantine"
+	}
+	if len(o.Statuses) == 0 {
+		o.Statuses = okta.InactiveStatuses
+	}
+}
+
+// Validate checks the actions are known.
+func (o *OffboardingOpts) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if len(o.Actions) == 0 {
+		return fmt.Errorf("no offboarding actions set")
+	}
+	for _, a := range o.Actions {
+		switch a {
+		case offboardInclude, offboardQuarantine:
+		case offboardUninstall:
+			if len(o.ManagedUninstalls) == 0 {
+				return fmt.Errorf("offboarding action uninstall needs managed-uninstalls")
+			}
+		default:
+			return fmt.Errorf("unknown offboarding action %q", a)
+		}
+	}
+
+	return nil
+}
+
+func (o *OffboardingOpts) has(action string) bool {
+	return o != nil && helpers.Contains(o.Actions, action)
+}
+
+// offboardedUsers returns the status of every offboarded user keyed by username.
+func (c *Client) offboardedUsers() map[string]string {
+	users := make(map[string]string)
+	if c.offboarding == nil {
+		return users
+	}
+
+	switch c.source {
+	case sourceMDM:
+		// the mdm does not know about deactivated users
+	case sourceSCIM:
+		for _, u := range c.scim.Users() {
+			if !u.Active {
+				users[username(u.PrimaryEmail())] = "INACTIVE"
+			}
+		}
+	default:
+		var search []string
+		for _, s := range c.offboarding.Statuses {
+			search = append(search, fmt.Sprintf("status eq %q", s))
+		}
+		oktaUsers, err := c.okta.ListUsers(strings.Join(search, " or "))
+		if err != nil {
+			c.log.Info().AnErr("error", err).Msg("failed to get offboarded okta users")
+			return users
+		}
+		for _, u := range oktaUsers {
+			users[username(u.Profile.Email)] = u.Status
+		}
+	}
+
+	return users
+}
+
+// offboard applies the offboarding actions to the manifest of a device
+// assigned to an offboarded user.
+func (c *Client) offboard(m *Manifest, status string) {
+	m.Metadata.Offboarded = status
+
+	if c.offboarding.has(offboardInclude) {
+		m.IncludedManifests = []string{c.offboarding.Include}
+		m.ManagedInstalls = nil
+		m.OptionalInstalls = nil
+	}
+
+	if c.offboarding.has(offboardUninstall) {
+		for _, item := range c.offboarding.ManagedUninstalls {
+			if !helpers.Contains(m.ManagedUninstalls, item) {
+				m.ManagedUninstalls = append(m.ManagedUninstalls, item)
+			}
+		}
+		m.ManagedInstalls = without(m.ManagedInstalls, m.ManagedUninstalls)
+		m.OptionalInstalls = without(m.OptionalInstalls, m.ManagedUninstalls)
+	}
+}
+
+// quarantine writes the manifest to the quarantine directory so munki no
+// longer finds it for the device.
+func (c *Client) quarantine(serial string, m *Manifest) error {
+	return c.writeManifestIn(c.offboarding.QuarantineDir, serial, m)
+}
+
+// unquarantine removes the quarantined manifest of a device whose user is
+// no longer offboarded.
+func (c *Client) unquarantine(serial string) {
+	if c.offboarding.has(offboardQuarantine) {
+		c.removeManifestIn(c.offboarding.QuarantineDir, serial)
+	}
+}
+
+func without(s []string, items []string) []string {
+	var res []string
+	for _, v := range s {
+		if !helpers.Contains(items, v) {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
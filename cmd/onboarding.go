@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// OnboardingOpts add an include manifest to the devices of new hires for a
+// number of days after their start date.
+type OnboardingOpts struct {
+	Include   string `json:"include"`   // include manifest, default includes/onboarding
+	Days      int    `json:"days"`      // days after the start date, default 14
+	Attribute string `json:"attribute"` // okta profile attribute, default startDate
+}
+
+// startDateLayouts are the formats tried when parsing a start date.
+var startDateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000Z",
+	"01/02/2006",
+}
+
+func (o *OnboardingOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if o.Include == "" {
+		o.Include = "includes/onboarding"
+	}
+	if o.Days == 0 {
+		o.Days = 14
+	}
+	if o.Attribute == "" {
+		o.Attribute = "startDate"
+	}
+}
+
+// onboarding reports whether a user with the start date is still within the
+// onboarding period at now. Devices set up before the start date are
+// included so new hires have the tools on their first day.
+func (o *OnboardingOpts) onboarding(startDate string, now time.Time) (bool, error) {
+	if o == nil || startDate == "" {
+		return false, nil
+	}
+
+	start, err := parseStartDate(startDate)
+	if err != nil {
+		return false, err
+	}
+
+	return now.Before(start.AddDate(0, 0, o.Days)), nil
+}
+
+func parseStartDate(v string) (time.Time, error) {
+	for _, layout := range startDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised start date %q", v)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// OrgOpts add include manifests from the management tree. Every node in the
+// management chain of the user adds its include, and the includes are
+// ordered by level so the manifest nests org, then department, then team.
+type OrgOpts struct {
+	// Levels from the broadest to the narrowest, default org, department
+	// and team. Department groups are placed at the department level.
+	Levels []string  `json:"levels"`
+	Nodes  []OrgNode `json:"nodes"`
+}
+
+// OrgNode gives everyone under a manager an include manifest.
+type OrgNode struct {
+	Manager string `json:"manager"` // email of the manager
+	Level   string `json:"level"`
+	Include string `json:"include"`
+}
+
+// departmentLevel is the level department group includes are placed at.
+const departmentLevel = "department"
+
+func (o *OrgOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if len(o.Levels) == 0 {
+		o.Levels = []string{"org", departmentLevel, "team"}
+	}
+}
+
+// Validate checks every node has a manager, a known level and an include.
+func (o *OrgOpts) Validate() error {
+	if o == nil {
+		return nil
+	}
+	for i, n := range o.Nodes {
+		if n.Manager == "" || n.Include == "" {
+			return fmt.Errorf("org node %d needs a manager and include", i)
+		}
+		if !helpers.Contains(o.Levels, n.Level) {
+			return fmt.Errorf("org node %s has unknown level %q", n.Manager, n.Level)
+		}
+	}
+
+	return nil
+}
+
+// includes returns the includes for the management chain and department
+// includes in level order. Within a level the nodes further up the chain
+// come first.
+func (o *OrgOpts) includes(chain []string, departments []string) []string {
+	if o == nil {
+		return departments
+	}
+
+	var res []string
+	placed := false
+	for _, level := range o.Levels {
+		for i := len(chain) - 1; i >= 0; i-- {
+			for _, n := range o.Nodes {
+				if n.Level == level && strings.EqualFold(n.Manager, chain[i]) {
+					res = appendMissing(res, n.Include)
+				}
+			}
+		}
+		if level == departmentLevel {
+			res = appendMissing(res, departments...)
+			placed = true
+		}
+	}
+	if !placed {
+		res = appendMissing(res, departments...)
+	}
+
+	return res
+}
+
+func appendMissing(s []string, items ...string) []string {
+	for _, item := range items {
+		if !helpers.Contains(s, item) {
+			s = append(s, item)
+		}
+	}
+
+	return s
+}
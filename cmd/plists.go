@@ -4,23 +4,75 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"text/template"
+	"path/filepath"
 
 	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/rules"
 	"howett.net/plist"
 )
 
-// UpdateInfo contains the information needed to update a manifest
-type UpdateInfo struct {
-	directory  string
-	department string
-	file       string
-	serial     string
-	user       string
+// Manifest is a munki client manifest.
+type Manifest struct {
+	DisplayName       []string  `plist:"display_name,omitempty"`
+	Catalogs          []string  `plist:"catalogs"`
+	IncludedManifests []string  `plist:"included_manifests"`
+	ManagedInstalls   []string  `plist:"managed_installs,omitempty"`
+	OptionalInstalls  []string  `plist:"optional_installs,omitempty"`
+	ManagedUninstalls []string  `plist:"managed_uninstalls,omitempty"`
+	Metadata          *Metadata `plist:"_metadata,omitempty"`
 }
 
-func (c *Client) copyGroupManifest(dest string) error {
-	source := fmt.Sprintf("%s/includes/department_template", c.directory)
+// Metadata records how the manifest was generated. Munki ignores it.
+type Metadata struct {
+	// CatalogsRule is the rule, catalog group or default that set the catalogs.
+	CatalogsRule string `plist:"catalogs_rule,omitempty"`
+	// Ring is the rollout ring the device is in.
+	Ring string `plist:"ring,omitempty"`
+	// Overrides lists the overrides merged into the manifest.
+	Overrides []string `plist:"overrides,omitempty"`
+	// Offboarded is the status of the deactivated user assigned to the device.
+	Offboarded string `plist:"offboarded,omitempty"`
+	// UserSource is how the user was resolved, mdm or console:<account>.
+	UserSource string `plist:"user_source,omitempty"`
+	// Class is the device class of a shared device.
+	Class string `plist:"class,omitempty"`
+	// MDM is the mdm the device record came from.
+	MDM string `plist:"mdm,omitempty"`
+}
+
+func (m *Manifest) actions() rules.Actions {
+	return rules.Actions{
+		IncludedManifests: m.IncludedManifests,
+		Catalogs:          m.Catalogs,
+		ManagedInstalls:   m.ManagedInstalls,
+		OptionalInstalls:  m.OptionalInstalls,
+	}
+}
+
+func (m *Manifest) setActions(a *rules.Actions) {
+	m.IncludedManifests = a.IncludedManifests
+	m.Catalogs = a.Catalogs
+	m.ManagedInstalls = a.ManagedInstalls
+	m.OptionalInstalls = a.OptionalInstalls
+}
+
+// include adds the include manifest if it is not already included.
+func (m *Manifest) include(include string) {
+	if !helpers.Contains(m.IncludedManifests, include) {
+		m.IncludedManifests = append(m.IncludedManifests, include)
+	}
+}
+
+// manifest templates, relative to the manifest directory
+const (
+	departmentTemplate = "includes/department_template"
+	siteTemplate       = "includes/site_template"
+)
+
+// copyManifestTemplate copies the template, relative to the manifest
+// directory, to dest.
+func (c *Client) copyManifestTemplate(template, dest string) error {
+	source := filepath.Join(c.directory, template)
 
 	in, err := os.Open(source)
 	if err != nil {
@@ -28,6 +80,10 @@ func (c *Client) copyGroupManifest(dest string) error {
 	}
 	defer in.Close()
 
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
 	out, err := os.Create(dest)
 	if err != nil {
 		return err
@@ -41,26 +97,49 @@ func (c *Client) copyGroupManifest(dest string) error {
 	return out.Close()
 }
 
-func (c *Client) copyTemplate(serial string, user string) error {
-	if _, err := os.Stat(c.directory); os.IsNotExist(err) {
-		c.log.Error().AnErr("error", err).Str("directory", c.directory).Msg("failed to stat")
+// writeManifest writes the manifest for the serial to the manifest directory.
+func (c *Client) writeManifest(serial string, m *Manifest) error {
+	return c.writeManifestTo(c.directory, serial, m)
+}
+
+// manifestSubdir resolves a directory relative to the manifest directory.
+func (c *Client) manifestSubdir(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
 	}
 
-	_, err := os.Create(c.directory + "/" + serial)
-	if err != nil {
-		c.log.Info().AnErr("error", err).Str("directory", c.directory).Msg("failed to create")
+	return filepath.Join(c.directory, dir)
+}
+
+// writeManifestIn writes the manifest to a directory outside of munki's
+// view, e.g. quarantine or archive, creating it when needed.
+func (c *Client) writeManifestIn(dir, serial string, m *Manifest) error {
+	dir = c.manifestSubdir(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
 	}
 
-	f, err := os.OpenFile(fmt.Sprintf("%s/%s", c.directory, serial), os.O_APPEND|os.O_WRONLY, os.ModeAppend)
-	if err != nil {
-		c.log.Info().AnErr("error", err).Str("directory", c.directory).Msg("failed to open")
+	return c.writeManifestTo(dir, serial, m)
+}
+
+// removeManifestIn removes the manifest for the serial from the directory
+// once the device is back to normal.
+func (c *Client) removeManifestIn(dir, serial string) {
+	err := os.Remove(filepath.Join(c.manifestSubdir(dir), serial))
+	if err == nil {
+		c.log.Debug().Str("serial", serial).Str("directory", dir).Msg("removed manifest")
+	} else if !os.IsNotExist(err) {
+		c.log.Info().AnErr("error", err).Str("serial", serial).Str("directory", dir).Msg("failed to remove manifest")
 	}
+}
 
-	if user == "" {
-		return noUser(f)
+func (c *Client) writeManifestTo(dir, serial string, m *Manifest) error {
+	data, err := plist.MarshalIndent(m, plist.XMLFormat, "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
 	}
 
-	return withUser(user, f)
+	return os.WriteFile(filepath.Join(dir, serial), data, 0o644)
 }
 
 func (c *Client) currentManifests() []string {
@@ -75,112 +154,3 @@ func (c *Client) currentManifests() []string {
 	}
 	return contents
 }
-
-func addDeptToManifest(u *UpdateInfo) error {
-	u.file = fmt.Sprintf("%s/%s", u.directory, u.serial)
-
-	return updatePlist(u)
-}
-
-func noUser(f *os.File) error {
-	t := template.Must(template.New("manifest").Parse(unknownUserManifestTemplate()))
-	return t.Execute(f, nil)
-}
-
-func withUser(user string, f *os.File) error {
-	data := struct {
-		Name string
-	}{
-		Name: user,
-	}
-
-	t := template.Must(template.New("manifest").Parse(userManifestTemplate()))
-	return t.Execute(f, data)
-}
-
-func userManifestTemplate() string {
-	return `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-	<key>display_name</key>
-	<array>
-		<string>{{.Name}}</string>
-	</array>
-	<key>catalogs</key>
-	<array>
-		<string>production</string>
-	</array>
-	<key>included_manifests</key>
-	<array>
-		<string>includes/apple_apps</string>
-		<string>includes/common_base</string>
-		<string>includes/optional_apps</string>
-		<string>includes/security</string>
-	</array>
-</dict>
-</plist>
-	`
-}
-
-func unknownUserManifestTemplate() string {
-	return `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-	<key>catalogs</key>
-	<array>
-		<string>production</string>
-	</array>
-	<key>included_manifests</key>
-	<array>
-		<string>includes/apple_apps</string>
-		<string>includes/common_base</string>
-		<string>includes/optional_apps</string>
-	</array>
-</dict>
-</plist>
-	`
-}
-
-func updatePlist(u *UpdateInfo) error {
-	f, err := os.Open(u.file)
-	if err != nil {
-		return fmt.Errorf("failed to open plist: %w", err)
-	}
-	defer f.Close()
-
-	p, err := io.ReadAll(f)
-	if err != nil {
-		return fmt.Errorf("failed to read plist: %w", err)
-	}
-
-	var pl map[string]interface{}
-	_, err = plist.Unmarshal(p, &pl)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal plist: %w", err)
-	}
-
-	department := fmt.Sprintf("includes/%s", u.department)
-	for key, val := range pl {
-		if key == "included_manifests" {
-			included := make([]string, len(val.([]interface{})))
-			for i, v := range val.([]interface{}) {
-				included[i] = v.(string)
-			}
-			if !helpers.Contains(included, department) {
-				included = append(included, department)
-				pl[key] = included
-			}
-		}
-	}
-
-	updatedPlist, err := plist.MarshalIndent(pl, plist.XMLFormat, "\t")
-	if err != nil {
-		return err
-	}
-
-	manifest := fmt.Sprintf("%s/%s", u.directory, u.serial)
-
-	return os.WriteFile(manifest, updatedPlist, 0o644)
-}
@@ -0,0 +1,394 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/mdm/client"
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/rules"
+	"howett.net/plist"
+)
+
+// munkiDomain is the preference domain munki reads its settings from.
+const munkiDomain = "ManagedInstalls"
+
+// ProfileOpts configure the configuration profile holding the munki
+// preferences, generated by the profile command.
+type ProfileOpts struct {
+	// Identifier is the payload identifier of the profile, default
+	// com.github.johnmikee.manifester.munki.
+	Identifier string `json:"identifier"`
+	// DisplayName is the name of the profile, and of the kandji custom
+	// profile, default Munki.
+	DisplayName  string `json:"display-name"`
+	Organization string `json:"organization"`
+	// Prefs are the ManagedInstalls preferences. String values are templates
+	// over the device facts, ClientIdentifier defaults to {{.Device.Serial}}.
+	Prefs map[string]interface{} `json:"prefs"`
+}
+
+func (o *ProfileOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if o.Identifier == "" {
+		o.Identifier = "com.github.johnmikee.manifester.munki"
+	}
+	if o.DisplayName == "" {
+		o.DisplayName = "Munki"
+	}
+	if o.Prefs == nil {
+		o.Prefs = make(map[string]interface{})
+	}
+	if _, ok := o.Prefs["ClientIdentifier"]; !ok {
+		o.Prefs["ClientIdentifier"] = "{{.Device.Serial}}"
+	}
+}
+
+// Validate checks the repo url is set and the preferences render.
+func (o *ProfileOpts) Validate() error {
+	if o == nil {
+		return fmt.Errorf("no profile configured")
+	}
+	if url, _ := o.Prefs["SoftwareRepoURL"].(string); url == "" {
+		return fmt.Errorf("profile prefs must set SoftwareRepoURL")
+	}
+	if _, err := o.prefs(&rules.Facts{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// mdmVariables are the device facts a fleet wide profile can reference. The
+// mdm replaces the variables when it installs the profile, other facts are
+// left empty.
+var mdmVariables = map[mdm.MDM]rules.Device{
+	mdm.Kandji: {Serial: "$SERIAL_NUMBER", Hostname: "$DEVICE_NAME", AssetTag: "$ASSET_TAG"},
+	mdm.Jamf:   {Serial: "$SERIALNUMBER", Hostname: "$COMPUTERNAME"},
+}
+
+// prefs renders the preferences for the facts.
+func (o *ProfileOpts) prefs(f *rules.Facts) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(o.Prefs))
+	for k, v := range o.Prefs {
+		rendered, err := renderPref(k, v, f)
+		if err != nil {
+			return nil, err
+		}
+		res[k] = rendered
+	}
+
+	return res, nil
+}
+
+// renderPref executes the templates in the preference value. Whole numbers
+// become integers since json has no integer type.
+func renderPref(key string, v interface{}, f *rules.Facts) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		t, err := template.New(key).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for %s: %w", key, err)
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, f); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", key, err)
+		}
+		return b.String(), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+		return v, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			r, err := renderPref(key, v[i], f)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = r
+		}
+		return res, nil
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k := range v {
+			r, err := renderPref(key+"."+k, v[k], f)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = r
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
+
+// Profile is a configuration profile with a single payload.
+type Profile struct {
+	PayloadContent      []map[string]interface{} `plist:"PayloadContent"`
+	PayloadDescription  string                   `plist:"PayloadDescription,omitempty"`
+	PayloadDisplayName  string                   `plist:"PayloadDisplayName"`
+	PayloadIdentifier   string                   `plist:"PayloadIdentifier"`
+	PayloadOrganization string                   `plist:"PayloadOrganization,omitempty"`
+	PayloadScope        string                   `plist:"PayloadScope"`
+	PayloadType         string                   `plist:"PayloadType"`
+	PayloadUUID         string                   `plist:"PayloadUUID"`
+	PayloadVersion      int                      `plist:"PayloadVersion"`
+}
+
+// profile builds the profile of the munki preferences for the facts. The
+// uuids are derived from the identifier and the serial so a profile that is
+// generated again replaces the installed one.
+func (o *ProfileOpts) profile(f *rules.Facts, serial string) (*Profile, error) {
+	prefs, err := o.prefs(f)
+	if err != nil {
+		return nil, err
+	}
+
+	identifier := o.Identifier
+	if serial != "" {
+		identifier += "." + serial
+	}
+
+	payload := prefs
+	payload["PayloadDisplayName"] = "Munki Preferences"
+	payload["PayloadIdentifier"] = identifier + "." + munkiDomain
+	payload["PayloadType"] = munkiDomain
+	payload["PayloadUUID"] = profileUUID(identifier + "." + munkiDomain)
+	payload["PayloadVersion"] = 1
+
+	return &Profile{
+		PayloadContent:      []map[string]interface{}{payload},
+		PayloadDescription:  "Munki preferences managed by manifester.",
+		PayloadDisplayName:  o.DisplayName,
+		PayloadIdentifier:   identifier,
+		PayloadOrganization: o.Organization,
+		PayloadScope:        "System",
+		PayloadType:         "Configuration",
+		PayloadUUID:         profileUUID(identifier),
+		PayloadVersion:      1,
+	}, nil
+}
+
+// profileUUID returns a name based uuid for the name.
+func profileUUID(name string) string {
+	h := sha1.Sum([]byte(name))
+	h[6] = (h[6] & 0x0f) | 0x50 // version 5
+	h[8] = (h[8] & 0x3f) | 0x80 // rfc 4122 variant
+
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16]))
+}
+
+// fleetProfile renders the profile for every device of the mdm, device
+// facts become the variables of the mdm.
+func (o *ProfileOpts) fleetProfile(m mdm.MDM) ([]byte, error) {
+	vars, ok := mdmVariables[m]
+	if !ok {
+		return nil, fmt.Errorf("%s has no profile variables, use -per-device", m)
+	}
+	p, err := o.profile(&rules.Facts{Device: vars}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return plist.MarshalIndent(p, plist.XMLFormat, "\t")
+}
+
+// deviceProfiles writes a profile for each device to the directory and
+// returns how many were written.
+func (c *Client) deviceProfiles(o *ProfileOpts, dir string, machines []MachineInfo) (int, error) {
+	written := 0
+	for i := range machines {
+		m := &machines[i]
+		if m.Serial == "" || !c.supportedPlatform(m.Device.Platform) || helpers.Contains(c.exclusions, m.Serial) {
+			continue
+		}
+
+		f := &rules.Facts{
+			Device: deviceFacts(m),
+			User:   rules.User{Username: m.Username, Email: m.Email},
+		}
+		p, err := o.profile(f, m.Serial)
+		if err != nil {
+			return written, err
+		}
+		data, err := plist.MarshalIndent(p, plist.XMLFormat, "\t")
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, m.Serial+".mobileconfig"), data, 0o644); err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, nil
+}
+
+// profileClient returns the client listing the devices for the per device
+// profiles. No directory is set up, so devices without a user in the mdm
+// get no console user.
+func profileClient(opts *Opts, provider mdm.Provider, log *logger.Logger) *Client {
+	exclusions, _ := activeExclusions(opts.Exclusions, time.Now())
+
+	return &Client{
+		mdm:        provider,
+		exclusions: exclusions,
+		platforms:  opts.Platforms,
+		log:        log,
+	}
+}
+
+type profileFlags struct {
+	configFile string
+	dryRun     bool
+	env        string
+	logLevel   string
+	logToFile  bool
+	mdm        string
+	out        string
+	perDevice  bool
+	service    string
+	upload     bool
+}
+
+// profileCommand generates the configuration profile that points munki at
+// the repo and sets ClientIdentifier so the per serial manifests are used.
+// It returns the exit code.
+//
+//	manifester profile -out profiles [-per-device] [-upload]
+func profileCommand(args []string) int {
+	f := &profileFlags{
+		configFile: "config.json",
+		env:        "dev",
+		logLevel:   "info",
+		mdm:        "kandji",
+		out:        "profiles",
+		service:    "manifester",
+	}
+
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	fs.StringVar(&f.configFile, "config-file", f.configFile, "Change config file location. [default: config.json]")
+	fs.BoolVar(&f.dryRun, "dry-run", f.dryRun, "Write the profiles without uploading them.")
+	fs.StringVar(&f.env, "env", f.env, "Set the environment. [prod | dev]")
+	fs.StringVar(&f.logLevel, "log-level", f.logLevel, "Set the log level.")
+	fs.BoolVar(&f.logToFile, "log-to-file", f.logToFile, "Log results to file.")
+	fs.StringVar(&f.mdm, "mdm", f.mdm, "Select which mdm [jamf | kandji], its variables are used in the fleet wide profile.")
+	fs.StringVar(&f.out, "out", f.out, "Directory the profiles are written to.")
+	fs.BoolVar(&f.perDevice, "per-device", f.perDevice, "Write a profile for each device in the mdm.")
+	fs.StringVar(&f.service, "service", f.service, "Set the service name.")
+	fs.BoolVar(&f.upload, "upload", f.upload, "Upload the fleet wide profile to the mdm.")
+	_ = fs.Parse(args)
+
+	log := logger.NewLogger(
+		&logger.Config{
+			ToFile:  f.logToFile,
+			Level:   f.logLevel,
+			Service: f.service,
+			Env:     f.env,
+		},
+	)
+
+	opts := readConf(f.configFile)
+	if opts == nil {
+		log.Error().Msg("failed to read config")
+		return 1
+	}
+	if err := opts.Profile.Validate(); err != nil {
+		log.Error().AnErr("error", err).Msg("invalid profile")
+		return 1
+	}
+	if f.upload && f.perDevice {
+		log.Error().Msg("only the fleet wide profile can be uploaded")
+		return 2
+	}
+	if err := os.MkdirAll(f.out, 0o755); err != nil {
+		log.Error().AnErr("error", err).Str("directory", f.out).Msg("failed to create profile directory")
+		return 1
+	}
+
+	m := mdm.MDM(f.mdm)
+	var provider mdm.Provider
+	if f.perDevice || f.upload {
+		cfg, err := getConfig(f.service)
+		if err != nil {
+			log.Error().AnErr("error", err).Msg("failed to get config")
+			return 1
+		}
+		mdms, err := mdmProviders(f.mdm, f.service, &cfg, opts, log)
+		if err != nil {
+			log.Error().AnErr("error", err).Msg("invalid mdm")
+			return 1
+		}
+		if len(mdms) != 1 {
+			log.Error().Msg("profiles are generated for a single mdm")
+			return 2
+		}
+		provider = client.New(mdms[0])
+	}
+
+	if f.perDevice {
+		c := profileClient(opts, provider, &log)
+		machines, err := c.getDevices()
+		if err != nil {
+			log.Error().AnErr("error", err).Msg("failed to get devices")
+			return 1
+		}
+		written, err := c.deviceProfiles(opts.Profile, f.out, machines)
+		if err != nil {
+			log.Error().AnErr("error", err).Msg("failed to write device profiles")
+			return 1
+		}
+		log.Info().Int("profiles", written).Str("directory", f.out).Msg("wrote device profiles")
+		return 0
+	}
+
+	data, err := opts.Profile.fleetProfile(m)
+	if err != nil {
+		log.Error().AnErr("error", err).Msg("failed to generate profile")
+		return 1
+	}
+	path := filepath.Join(f.out, munkiDomain+".mobileconfig")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Error().AnErr("error", err).Str("path", path).Msg("failed to write profile")
+		return 1
+	}
+	log.Info().Str("path", path).Msg("wrote profile")
+
+	if !f.upload {
+		return 0
+	}
+	uploader, ok := provider.(mdm.ProfileUploader)
+	if !ok {
+		log.Error().Str("mdm", f.mdm).Msg("mdm does not support uploading profiles")
+		return 1
+	}
+	if f.dryRun {
+		log.Info().Str("profile", opts.Profile.DisplayName).Msg("dry run, not uploading the profile")
+		return 0
+	}
+	if err := uploader.UploadProfile(opts.Profile.DisplayName, data); err != nil {
+		log.Error().AnErr("error", err).Msg("failed to upload profile")
+		return 1
+	}
+	log.Info().Str("profile", opts.Profile.DisplayName).Msg("uploaded profile")
+
+	return 0
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+// report summarises a run.
+type report struct {
+	written int      // manifests written
+	skipped int      // devices excluded or with an existing manifest
+	ignored int      // devices on platforms without manifests
+	failed  int      // manifests that failed to write
+	expired []string // rules, overrides and exclusions that expired
+	// offboarded lists the devices assigned to deactivated users
+	offboarded []offboardedDevice
+	// inactive lists the removed, missing and stale devices
+	inactive []inactiveDevice
+	// sources counts the manifests written by the mdm the device came from
+	sources map[string]int
+	// writtenBack counts the device records updated in the mdm
+	writtenBack int
+}
+
+func (r *report) write(source string) {
+	r.written++
+	if source == "" {
+		return
+	}
+	if r.sources == nil {
+		r.sources = make(map[string]int)
+	}
+	r.sources[source]++
+}
+
+type offboardedDevice struct {
+	serial  string
+	user    string
+	status  string
+	actions []string
+}
+
+func (r *report) offboard(serial, user, status string, actions []string) {
+	r.offboarded = append(r.offboarded, offboardedDevice{serial: serial, user: user, status: status, actions: actions})
+}
+
+type inactiveDevice struct {
+	serial string
+	reason string
+	action string
+}
+
+func (r *report) deactivate(serial, reason, action string) {
+	r.inactive = append(r.inactive, inactiveDevice{serial: serial, reason: reason, action: action})
+}
+
+func (r *report) expire(kind string, names ...string) {
+	for _, name := range names {
+		r.expired = append(r.expired, kind+" "+name)
+	}
+}
+
+// log writes the summary of the run.
+func (r *report) log(l *logger.Logger) {
+	sort.Strings(r.expired)
+	for _, e := range r.expired {
+		l.Info().Str("entry", e).Msg("dropped expired entry")
+	}
+
+	for _, d := range r.offboarded {
+		l.Info().
+			Str("serial", d.serial).
+			Str("user", d.user).
+			Str("status", d.status).
+			Strs("actions", d.actions).
+			Msg("offboarded device")
+	}
+
+	for _, d := range r.inactive {
+		l.Info().
+			Str("serial", d.serial).
+			Str("reason", d.reason).
+			Str("action", d.action).
+			Msg("inactive device")
+	}
+
+	sources := make([]string, 0, len(r.sources))
+	for s := range r.sources {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+	for _, s := range sources {
+		l.Info().Str("mdm", s).Int("written", r.sources[s]).Msg("manifests by mdm")
+	}
+
+	l.Info().
+		Int("written", r.written).
+		Int("skipped", r.skipped).
+		Int("ignored", r.ignored).
+		Int("failed", r.failed).
+		Int("written_back", r.writtenBack).
+		Int("expired", len(r.expired)).
+		Int("offboarded", len(r.offboarded)).
+		Int("inactive", len(r.inactive)).
+		Msg("run summary")
+}
@@ -2,22 +2,77 @@ package cmd
 
 import (
 	"os"
+	"text/template"
+	"time"
 
 	"github.com/johnmikee/manifester/mdm"
 	"github.com/johnmikee/manifester/okta"
 	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/pkg/naming"
+	"github.com/johnmikee/manifester/pkg/rings"
+	"github.com/johnmikee/manifester/rules"
+	"github.com/johnmikee/manifester/scim"
 )
 
 type Client struct {
-	mdm        mdm.Provider
-	okta       *okta.Client
-	log        *logger.Logger
-	directory  string   // munki manifest directory
-	exclusions []string // serial numbers to exclude
-	filter     string   // okta filter
+	mdm         mdm.Provider
+	okta        *okta.Client
+	log         *logger.Logger
+	directory   string   // munki manifest directory
+	exclusions  []string // serial numbers to exclude
+	filter      *okta.GroupFilter
+	source      string // identity source [okta | okta-profile | scim | mdm]
+	listGroups  *okta.ListGroupsOptions
+	profile     profileOpts
+	scim        *scim.Store
+	naming      *naming.Policy // include manifest naming
+	rules       *rules.Ruleset // targeting rules evaluated per device
+	rulesFile   string         // path of the rules file, empty for the default rules
+	catalogs    *rules.CatalogPolicy
+	rings       *rings.Config // staged rollout rings
+	overrides   *rules.Overrides
+	report      report
+	onboarding  *OnboardingOpts    // new hire include manifest
+	offboarding *OffboardingOpts   // devices of deactivated users
+	org         *OrgOpts           // includes from the management tree
+	sites       *SiteOpts          // site includes from the blueprint or location
+	display     *template.Template // display_name template
+	platforms   []string           // device platforms that get manifests
+	inactive    *InactiveOpts      // removed, missing and stale devices
+	console     *ConsoleUserOpts   // users of unassigned devices from local accounts
+	classes     rules.Classes      // shared device classes
+	writeBack   *WriteBackOpts     // record manifests on the mdm device records
+	dryRun      bool               // do not change the mdm
+
+	locationAttribute string                   // okta profile attribute holding the location
+	managerAttribute  string                   // okta profile attribute referencing the manager
+	directoryUsers    map[string]directoryUser // identity provider users, see people()
+	managerIndex      map[string]string        // manager references to usernames, see managers()
+	createdSites      map[string]bool          // site includes created this run
+	departmentSources []string                 // department sources in order of precedence
+	mdmDepartments    map[string][]string      // department members recorded in the mdm
+}
+
+// profileOpts control how departments are derived from the okta user profile.
+type profileOpts struct {
+	attribute string            // profile attribute holding the department
+	mapping   map[string]string // attribute value to include manifest name
+	search    string            // okta search expression used to list users
 }
 
 func Execute() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "scim":
+			serveSCIM(os.Args[2:])
+			return
+		case "rules":
+			os.Exit(rulesCommand(os.Args[2:]))
+		case "profile":
+			os.Exit(profileCommand(os.Args[2:]))
+		}
+	}
+
 	client := setup()
 	err := client.run()
 	if err != nil {
@@ -28,23 +83,32 @@ func Execute() {
 
 func (c *Client) run() error {
 	/*
-		first, remove the current entries. we exlude the includes/ directory
+		first, get the machines from the mdm. inactive devices keep their
+		manifest through the cleanup below so it can be archived.
+	*/
+	machines, err := c.getDevices()
+	if err != nil {
+		c.log.Info().AnErr("error", err).Msg("failed to get devices")
+		return err
+	}
+
+	/*
+		then remove the current entries. we exlude the includes/ directory
 		as these are manually created and managed. this ensures that if a user
 		switched departments their manifests are updated accordingly and is less expensive than
 		opening each file to check if it is current and then closing it.
 	*/
-
-	err := c.removeEntries()
+	err = c.removeEntries(c.inactiveSerials(machines, time.Now())...)
 	if err != nil {
 		c.log.Info().AnErr("error", err).Msg("failed to remove entries")
 		return err
 	}
 	/*
-		next we build our manifests. we do this by getting all the machines from kandji
-		and then iterating through them taking the serial number to make the manifest.
+		next we build our manifests. we do this by iterating through the machines
+		taking the serial number to make the manifest.
 		we take the user assigned to the device, unless we cannot, and get their department from okta.
 
 		this allows us to target specific groups of users with specific manifests. or not.
 	*/
-	return c.manifests()
+	return c.manifests(machines)
 }
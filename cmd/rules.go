@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/johnmikee/manifester/rules"
+)
+
+// rulesCommand runs the rules subcommands and returns the exit code.
+//
+//	manifester rules test -rules rules.yaml -fixtures fixtures.yaml
+func rulesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "usage: manifester rules test -rules <file> -fixtures <file>")
+		return 2
+	}
+
+	var rulesFile, fixturesFile string
+	fs := flag.NewFlagSet("rules test", flag.ExitOnError)
+	fs.StringVar(&rulesFile, "rules", "", "Path to the rules file. [default: built in rules]")
+	fs.StringVar(&fixturesFile, "fixtures", "fixtures.json", "Path to the fixture devices.")
+	_ = fs.Parse(args[1:])
+
+	ruleset := rules.Default()
+	if rulesFile != "" {
+		var err error
+		ruleset, err = rules.Load(rulesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load rules: %s\n", err)
+			return 1
+		}
+	}
+
+	fixtures, err := rules.LoadFixtures(fixturesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load fixtures: %s\n", err)
+		return 1
+	}
+
+	return testRules(ruleset, fixtures)
+}
+
+// testRules prints the result of each fixture and returns 1 if any failed.
+func testRules(ruleset *rules.Ruleset, fixtures []rules.Fixture) int {
+	failed := 0
+	for i := range fixtures {
+		f := &fixtures[i]
+		res, diffs := ruleset.Test(f)
+
+		status := "PASS"
+		if len(diffs) > 0 {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s (rules: %s)\n", status, f.Name, strings.Join(res.Matched, ", "))
+		for _, d := range diffs {
+			fmt.Printf("    %s\n", d)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", len(fixtures)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/scim"
+)
+
+type scimFlags struct {
+	configFile string
+	env        string
+	listen     string
+	logLevel   string
+	logToFile  bool
+	service    string
+	tlsCert    string
+	tlsKey     string
+}
+
+// serveSCIM runs the SCIM 2.0 service provider so identity providers can push
+// users and group membership into the local store used as a department source.
+//
+//	manifester scim -listen :8443 -tls-cert cert.pem -tls-key key.pem
+func serveSCIM(args []string) {
+	f := &scimFlags{
+		configFile: "config.json",
+		env:        "dev",
+		listen:     ":8080",
+		logLevel:   "debug",
+		service:    "manifester",
+	}
+
+	fs := flag.NewFlagSet("scim", flag.ExitOnError)
+	fs.StringVar(&f.configFile, "config-file", f.configFile, "Change config file location. [default: config.json]")
+	fs.StringVar(&f.env, "env", f.env, "Set the environment. [prod | dev]")
+	fs.StringVar(&f.listen, "listen", f.listen, "Address to listen on.")
+	fs.StringVar(&f.logLevel, "log-level", f.logLevel, "Set the log level.")
+	fs.BoolVar(&f.logToFile, "log-to-file", f.logToFile, "Log results to file.")
+	fs.StringVar(&f.service, "service", f.service, "Set the service name.")
+	fs.StringVar(&f.tlsCert, "tls-cert", f.tlsCert, "Path to the TLS certificate.")
+	fs.StringVar(&f.tlsKey, "tls-key", f.tlsKey, "Path to the TLS key.")
+	_ = fs.Parse(args)
+
+	log := logger.NewLogger(
+		&logger.Config{
+			ToFile:  f.logToFile,
+			Level:   f.logLevel,
+			Service: f.service,
+			Env:     f.env,
+		},
+	)
+
+	cfg, err := getConfig(f.service)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Msg("failed to get config")
+	}
+	if cfg.SCIMToken == "" {
+		log.Fatal().Msg("scim_token must be set to serve scim")
+	}
+
+	opts := readConf(f.configFile)
+	if opts == nil {
+		log.Fatal().Msg("failed to read config")
+		return
+	}
+
+	store, err := scim.Open(opts.SCIMStore)
+	if err != nil {
+		log.Fatal().AnErr("error", err).Str("store", opts.SCIMStore).Msg("failed to open scim store")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(scim.BasePath+"/", scim.NewServer(
+		&scim.Config{
+			Store: store,
+			Token: cfg.SCIMToken,
+			Log:   &log,
+		},
+	))
+
+	srv := &http.Server{
+		Addr:              f.listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Info().Str("listen", f.listen).Str("store", opts.SCIMStore).Msg("serving scim")
+	if f.tlsCert != "" {
+		err = srv.ListenAndServeTLS(f.tlsCert, f.tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Info().AnErr("error", err).Msg("scim server stopped")
+		os.Exit(1)
+	}
+}
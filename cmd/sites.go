@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"path"
+	"strings"
+	"unicode"
+)
+
+// SiteOpts add an include manifest for the site of each device, e.g.
+// includes/site_london, with the printers, VPN profiles and language packs
+// of the office.
+type SiteOpts struct {
+	// Blueprints maps an MDM blueprint to a site. It is checked first.
+	Blueprints map[string]string `json:"blueprints"`
+	// Attribute is the okta profile attribute holding the location of the
+	// user, e.g. city, countryCode or officeLocation.
+	Attribute string `json:"attribute"`
+	// Map translates attribute values into sites. Unmapped values are used
+	// as the site name.
+	Map map[string]string `json:"map"`
+	// Prefix of the include manifest, default includes/site_.
+	Prefix string `json:"prefix"`
+	// Template missing site manifests are created from, default
+	// includes/site_template.
+	Template string `json:"template"`
+}
+
+func (s *SiteOpts) setDefaults() {
+	if s == nil {
+		return
+	}
+	if s.Prefix == "" {
+		s.Prefix = "includes/site_"
+	}
+	if s.Template == "" {
+		s.Template = siteTemplate
+	}
+}
+
+// site returns the site of the device from its blueprint, then the
+// location attribute of the user.
+func (s *SiteOpts) site(blueprint, location string) string {
+	if s == nil {
+		return ""
+	}
+
+	if site, ok := s.Blueprints[blueprint]; ok && blueprint != "" {
+		return siteName(site)
+	}
+	if location == "" {
+		return ""
+	}
+	if site, ok := s.Map[location]; ok {
+		return siteName(site)
+	}
+
+	return siteName(location)
+}
+
+// siteName lower cases the site and replaces anything but letters and
+// digits with an underscore, e.g. New York becomes new_york.
+func siteName(site string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, strings.TrimSpace(site)), "_")
+}
+
+// siteInclude returns the site include manifest for the device, creating it
+// from the site template when it does not exist.
+func (c *Client) siteInclude(m *MachineInfo, location string) string {
+	site := c.sites.site(m.Device.Blueprint, location)
+	if site == "" {
+		return ""
+	}
+
+	// the site name goes through the naming policy, the prefix keeps the
+	// directory of the site manifests
+	dir, prefix := path.Split(c.sites.Prefix)
+	name, err := c.naming.Name(prefix + site)
+	if err != nil {
+		c.log.Info().AnErr("error", err).Str("site", site).Msg("skipping site with invalid name")
+		return ""
+	}
+	include := dir + name
+	if !c.createdSites[include] {
+		err := c.createIncludeManifest(include, c.sites.Template)
+		if err != nil {
+			c.log.Info().AnErr("error", err).Str("site", site).Msg("failed to create site manifest")
+			return ""
+		}
+		if c.createdSites == nil {
+			c.createdSites = make(map[string]bool)
+		}
+		c.createdSites[include] = true
+	}
+
+	return include
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/johnmikee/manifester/mdm"
+)
+
+// WriteBackOpts control writing the manifest and departments of each device
+// back to its record in the mdm, so the helpdesk can see them there.
+type WriteBackOpts struct {
+	// TagPrefix marks the kandji tags manifester manages, default munki:.
+	TagPrefix string `json:"tag-prefix"`
+	// Attribute is the jamf text extension attribute, default Munki Manifest.
+	Attribute string `json:"attribute"`
+}
+
+func (o *WriteBackOpts) setDefaults() {
+	if o == nil {
+		return
+	}
+	if o.TagPrefix == "" {
+		o.TagPrefix = "munki:"
+	}
+	if o.Attribute == "" {
+		o.Attribute = "Munki Manifest"
+	}
+}
+
+// writer returns the mdm writer when write back is enabled and the mdm
+// supports it.
+func (c *Client) writer() mdm.Writer {
+	if c.writeBack == nil {
+		return nil
+	}
+	w, ok := c.mdm.(mdm.Writer)
+	if !ok {
+		c.log.Info().Msg("mdm does not support write back")
+		return nil
+	}
+
+	return w
+}
+
+// writeBackDevice records the manifest and departments of the device in the
+// mdm. Nothing is written on a dry run.
+func (c *Client) writeBackDevice(w mdm.Writer, m *MachineInfo, depts []department) {
+	if w == nil {
+		return
+	}
+
+	a := mdm.Assignment{Manifest: m.Serial}
+	for _, d := range depts {
+		a.Departments = append(a.Departments, d.name)
+	}
+
+	if c.dryRun {
+		c.log.Info().Str("serial", m.Serial).Str("assignment", a.String()).Msg("dry run, not writing back to the mdm")
+		return
+	}
+
+	err := w.WriteBack(m.Device, a)
+	if errors.Is(err, mdm.ErrUnsupported) {
+		c.log.Trace().Str("serial", m.Serial).Str("mdm", string(m.Device.Source)).Msg("mdm does not support write back")
+		return
+	}
+	if err != nil {
+		c.log.Info().AnErr("error", err).Str("serial", m.Serial).Msg("failed to write back to the mdm")
+		return
+	}
+	c.report.writtenBack++
+}
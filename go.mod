@@ -3,9 +3,11 @@ module github.com/johnmikee/manifester
 go 1.20
 
 require (
+	github.com/expr-lang/expr v1.17.8
 	github.com/johnmikee/yae v0.0.0-20230719140038-adcf0b96b2cf
 	github.com/rs/zerolog v1.30.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/sirupsen/logrus v1.7.0 // indirect
@@ -18,7 +20,7 @@ require (
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/zalando/go-keyring v0.2.3 // indirect
+	github.com/zalando/go-keyring v0.2.3
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/term v0.9.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
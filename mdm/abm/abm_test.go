@@ -0,0 +1,166 @@
+package abm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+func ecKey(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// fakeABM serves the token endpoint and a small organization.
+func fakeABM(t *testing.T) (*httptest.Server, *int) {
+	var tokenRequests int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("Error parsing form: %s", err)
+			}
+			if r.Form.Get("scope") != businessScope || r.Form.Get("client_id") != "BUSINESSAPI.client" {
+				t.Errorf("Unexpected scope %s or client_id %s", r.Form.Get("scope"), r.Form.Get("client_id"))
+			}
+
+			header, claims := decode(t, r.Form.Get("client_assertion"))
+			if header["alg"] != "ES256" || header["kid"] != "key-id" {
+				t.Errorf("Unexpected assertion header %v", header)
+			}
+			if claims["iss"] != "BUSINESSAPI.client" || claims["aud"] != srv.URL+"/audience" {
+				t.Errorf("Unexpected assertion claims %v", claims)
+			}
+
+			fmt.Fprint(w, `{"access_token":"access","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/orgDevices":
+			if r.URL.Query().Get("cursor") == "" {
+				fmt.Fprintf(w, `{"data":[
+					{"id":"C02ABC123","attributes":{"serialNumber":"C02ABC123","deviceModel":"MacBook Pro 14\"","productFamily":"Mac"}},
+					{"id":"C02XYZ789","attributes":{"serialNumber":"C02XYZ789","deviceModel":"MacBook Air","productFamily":"Mac"}}
+				],"links":{"next":"%s/v1/orgDevices?cursor=2"}}`, srv.URL)
+				return
+			}
+			fmt.Fprint(w, `{"data":[{"id":"DMPIPAD01","attributes":{"serialNumber":"DMPIPAD01","productFamily":"iPad"}}],"links":{}}`)
+		case "/v1/mdmServers":
+			fmt.Fprint(w, `{"data":[{"id":"1","attributes":{"serverName":"Kandji"}},{"id":"2","attributes":{"serverName":"Jamf"}}]}`)
+		case "/v1/mdmServers/1/relationships/devices":
+			fmt.Fprint(w, `{"data":[{"type":"orgDevices","id":"C02ABC123"},{"type":"orgDevices","id":"DMPIPAD01"}]}`)
+		case "/v1/mdmServers/2/relationships/devices":
+			fmt.Fprint(w, `{"data":[{"type":"orgDevices","id":"C02XYZ789"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return srv, &tokenRequests
+}
+
+func TestListAllDevices(t *testing.T) {
+	srv, tokenRequests := fakeABM(t)
+	defer srv.Close()
+
+	setup := func(servers ...string) *Client {
+		c := &Client{}
+		c.Setup(mdm.Config{
+			URL: srv.URL + "/v1/",
+			Log: logger.Default(),
+			ProviderSpecificConfig: &Config{
+				ClientID:   "BUSINESSAPI.client",
+				KeyID:      "key-id",
+				PrivateKey: ecKey(t),
+				Servers:    servers,
+				TokenURL:   srv.URL + "/token",
+				Audience:   srv.URL + "/audience",
+			},
+		})
+		return c
+	}
+
+	devices, err := setup().ListAllDevices()
+	if err != nil {
+		t.Fatalf("ListAllDevices returned an error: %s", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("Expected 3 devices, got %d", len(devices))
+	}
+	d := devices[0].Device
+	if d.SerialNumber != "C02ABC123" || d.Platform != "Mac" || d.Server != "Kandji" || d.Source != mdm.ABM {
+		t.Errorf("Unexpected device %+v", d)
+	}
+	if devices[0].Users != nil {
+		t.Errorf("Expected no user, got %v", devices[0].Users)
+	}
+	if *tokenRequests != 1 {
+		t.Errorf("Expected the token to be cached, got %d token requests", *tokenRequests)
+	}
+
+	t.Run("servers", func(t *testing.T) {
+		devices, err := setup("Jamf").ListAllDevices()
+		if err != nil {
+			t.Fatalf("ListAllDevices returned an error: %s", err)
+		}
+		if len(devices) != 1 || devices[0].Device.SerialNumber != "C02XYZ789" {
+			t.Errorf("Expected only the device assigned to Jamf, got %v", devices)
+		}
+	})
+}
+
+func TestRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+	ts := newTokenSource(&Config{PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})}, nil)
+	if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "ES256") {
+		t.Errorf("Expected an error for an RSA key, got %v", err)
+	}
+}
+
+func decode(t *testing.T, s string) (map[string]interface{}, map[string]interface{}) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %d", len(parts))
+	}
+
+	var res [2]map[string]interface{}
+	for i := range res {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatalf("Error decoding part %d: %s", i, err)
+		}
+		if err := json.Unmarshal(b, &res[i]); err != nil {
+			t.Fatalf("Error unmarshalling part %d: %s", i, err)
+		}
+	}
+
+	return res[0], res[1]
+}
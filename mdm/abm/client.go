@@ -0,0 +1,127 @@
+package abm
+
+import (
+	"net/http"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/pkg/oauth"
+	"github.com/johnmikee/manifester/pkg/requester"
+)
+
+// Endpoints of Apple Business Manager and Apple School Manager.
+//   - https://developer.apple.com/documentation/apple-school-and-business-manager-api
+const (
+	BusinessURL     = "https://api-business.apple.com/v1/"
+	SchoolURL       = "https://api-school.apple.com/v1/"
+	DefaultTokenURL = "https://account.apple.com/auth/oauth2/token"
+	DefaultAudience = "https://account.apple.com/auth/oauth2/v2/token"
+
+	businessScope = "business.api"
+	schoolScope   = "school.api"
+)
+
+type Client struct {
+	baseURL string
+	client  *http.Client
+	log     logger.Logger
+	servers []string
+	tokens  *oauth.TokenSource
+}
+
+// Config is the apple business manager specific configuration passed as
+// mdm.Config.ProviderSpecificConfig. mdm.Config.URL overrides the api url.
+type Config struct {
+	// ClientID is the client id of the api account, it is also the issuer.
+	ClientID string
+	// KeyID is the id of the private key of the api account.
+	KeyID string
+	// PrivateKey is the PEM encoded P-256 key downloaded from ABM.
+	PrivateKey []byte
+	// School uses the Apple School Manager url and scope.
+	School bool
+	// Servers limits the devices to those assigned to these mdm servers,
+	// by name. All devices in the org are listed when empty.
+	Servers []string
+	// TokenURL and Audience override the endpoints of the client
+	// assertion flow, e.g. to test against a local server.
+	TokenURL string
+	Audience string
+}
+
+// Setup implements mdm.Provider.
+func (c *Client) Setup(config mdm.Config) {
+	c.client = config.Client
+	c.log = logger.ChildLogger("abm", &config.Log)
+
+	ac, ok := config.ProviderSpecificConfig.(*Config)
+	if !ok || ac == nil {
+		ac = &Config{}
+	}
+
+	base := BusinessURL
+	if ac.School {
+		base = SchoolURL
+	}
+	if config.URL != "" {
+		base = config.URL
+	}
+	c.baseURL = helpers.URLShaper(base, "")
+	c.servers = ac.Servers
+
+	c.tokens = newTokenSource(ac, c.client)
+	if err := c.tokens.Err(); err != nil {
+		c.log.Error().Err(err).Msg("failed to parse abm private key")
+	}
+}
+
+func (c *Client) newRequest(method, url string, override bool, body interface{}) (*http.Request, error) {
+	return requester.New(method, c.baseURL, url, override, body)
+}
+
+func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := requester.Do(c.client, req, v)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// the token may have expired early, fetch a new one on the next request
+		c.tokens.Reset()
+	}
+
+	return resp, err
+}
+
+// newTokenSource returns the token source of the api account. Apple only
+// accepts ES256 client assertions.
+func newTokenSource(c *Config, client *http.Client) *oauth.TokenSource {
+	scope := businessScope
+	if c.School {
+		scope = schoolScope
+	}
+	tokenURL := c.TokenURL
+	if tokenURL == "" {
+		tokenURL = DefaultTokenURL
+	}
+	audience := c.Audience
+	if audience == "" {
+		audience = DefaultAudience
+	}
+
+	return oauth.NewTokenSource(&oauth.Config{
+		Name:       "abm",
+		ClientID:   c.ClientID,
+		KeyID:      c.KeyID,
+		PrivateKey: c.PrivateKey,
+		Scopes:     []string{scope},
+		TokenURL:   tokenURL,
+		Audience:   audience,
+		Algorithms: []string{"ES256"},
+		Client:     client,
+	})
+}
@@ -0,0 +1,139 @@
+package abm
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// pageLimit is the largest page the api returns.
+const pageLimit = 1000
+
+// page is a page of resources from the api.
+type page[T any] struct {
+	Data  []T `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// OrgDevice is a device in the organization.
+type OrgDevice struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		SerialNumber       string     `json:"serialNumber"`
+		DeviceModel        string     `json:"deviceModel"`
+		ProductFamily      string     `json:"productFamily"`
+		ProductType        string     `json:"productType"`
+		Status             string     `json:"status"`
+		AddedToOrgDateTime *time.Time `json:"addedToOrgDateTime"`
+	} `json:"attributes"`
+}
+
+// MDMServer is a device management service in the organization.
+type MDMServer struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		ServerName string `json:"serverName"`
+		ServerType string `json:"serverType"`
+	} `json:"attributes"`
+}
+
+// linkage is a reference to a related resource.
+type linkage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// paginate requests url and follows the next link until every page has
+// been decoded, returning the combined results.
+func paginate[T any](c *Client, url string) ([]T, error) {
+	var override bool
+
+	var res []T
+	for url != "" {
+		req, err := c.newRequest(http.MethodGet, url, override, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var p page[T]
+		resp, err := c.do(req, &p)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("abm returned %s for %s", resp.Status, req.URL.Path)
+		}
+
+		res = append(res, p.Data...)
+		url = p.Links.Next
+		override = true
+	}
+
+	return res, nil
+}
+
+// assignedServers returns the name of the mdm server each device is assigned
+// to keyed by device id. Listing the devices of every server takes far
+// fewer requests than asking for the server of every device.
+func (c *Client) assignedServers() (map[string]string, error) {
+	servers, err := paginate[MDMServer](c, fmt.Sprintf("mdmServers?limit=%d", pageLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string)
+	for _, s := range servers {
+		devices, err := paginate[linkage](c, fmt.Sprintf("mdmServers/%s/relationships/devices?limit=%d", s.ID, pageLimit))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			res[d.ID] = s.Attributes.ServerName
+		}
+	}
+
+	return res, nil
+}
+
+// ListAllDevices implements mdm.Provider. The devices have no user, they are
+// known to apple before they are enrolled in an mdm.
+func (c *Client) ListAllDevices() ([]mdm.MachineInfo, error) {
+	devices, err := paginate[OrgDevice](c, fmt.Sprintf("orgDevices?limit=%d", pageLimit))
+	if err != nil {
+		c.log.Info().AnErr("error", err).Msg("listing devices")
+		return nil, err
+	}
+
+	servers, err := c.assignedServers()
+	if err != nil {
+		c.log.Info().AnErr("error", err).Msg("listing mdm servers")
+		return nil, err
+	}
+
+	var res []mdm.MachineInfo
+	for _, d := range devices {
+		server := servers[d.ID]
+		if len(c.servers) > 0 && !helpers.Contains(c.servers, server) {
+			continue
+		}
+
+		res = append(res, mdm.MachineInfo{
+			Device: mdm.Device{
+				DeviceID:     d.ID,
+				SerialNumber: d.Attributes.SerialNumber,
+				Model:        d.Attributes.DeviceModel,
+				Platform:     d.Attributes.ProductFamily,
+				Server:       server,
+				Source:       mdm.ABM,
+			},
+		})
+	}
+
+	return res, nil
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/mdm/abm"
 	"github.com/johnmikee/manifester/mdm/jamf"
 	"github.com/johnmikee/manifester/mdm/kandji"
 )
@@ -36,6 +37,8 @@ func createMDMProvider(providerName mdm.MDM) mdm.Provider {
 		return &jamf.Client{}
 	case mdm.Kandji:
 		return &kandji.Client{}
+	case mdm.ABM:
+		return &abm.Client{}
 	default:
 		return nil
 	}
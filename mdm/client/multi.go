@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+// Multi lists the devices of several providers as one, e.g. while devices
+// are migrated from one mdm to another.
+type Multi struct {
+	providers  []mdm.Provider
+	names      []mdm.MDM
+	precedence mdm.Precedence
+	log        logger.Logger
+}
+
+// NewMulti creates and sets up a provider for each configuration. The
+// device lists are merged with the precedence, provider order matters for
+// mdm.PrecedenceOrder. A single configuration returns the provider itself.
+func NewMulti(ms []*MDM, precedence mdm.Precedence) mdm.Provider {
+	if len(ms) == 1 {
+		return New(ms[0])
+	}
+
+	m := &Multi{precedence: precedence}
+	for _, c := range ms {
+		m.providers = append(m.providers, New(c))
+		m.names = append(m.names, c.MDM)
+	}
+	if len(ms) > 0 {
+		m.log = logger.ChildLogger("mdm", &ms[0].Config.Log)
+	}
+
+	return m
+}
+
+// Setup implements mdm.Provider, the providers are set up by NewMulti.
+func (m *Multi) Setup(config mdm.Config) {}
+
+// ListAllDevices implements mdm.Provider. It fails when any provider does,
+// a partial inventory would look like devices left the fleet.
+func (m *Multi) ListAllDevices() ([]mdm.MachineInfo, error) {
+	var lists [][]mdm.MachineInfo
+	total := 0
+	for i, p := range m.providers {
+		devices, err := p.ListAllDevices()
+		if err != nil {
+			return nil, fmt.Errorf("listing %s devices: %w", m.names[i], err)
+		}
+		m.log.Debug().Str("mdm", string(m.names[i])).Int("devices", len(devices)).Msg("listed devices")
+		lists = append(lists, devices)
+		total += len(devices)
+	}
+
+	res := mdm.Merge(lists, m.precedence)
+	m.log.Info().Int("devices", len(res)).Int("merged", total-len(res)).Msg("merged devices")
+
+	return res, nil
+}
+
+// WriteBack implements mdm.Writer by writing to the provider the device
+// came from. It returns mdm.ErrUnsupported when that provider cannot write
+// back.
+func (m *Multi) WriteBack(d mdm.Device, a mdm.Assignment) error {
+	for i, p := range m.providers {
+		if m.names[i] != d.Source {
+			continue
+		}
+		w, ok := p.(mdm.Writer)
+		if !ok {
+			break
+		}
+		return w.WriteBack(d, a)
+	}
+
+	return fmt.Errorf("write back to %s: %w", d.Source, mdm.ErrUnsupported)
+}
+
+// ConsoleUsers implements mdm.ConsoleUserProvider by asking the provider
+// each device came from.
+func (m *Multi) ConsoleUsers(devices []mdm.Device) map[string]mdm.ConsoleUser {
+	bySource := make(map[mdm.MDM][]mdm.Device)
+	for _, d := range devices {
+		bySource[d.Source] = append(bySource[d.Source], d)
+	}
+
+	res := make(map[string]mdm.ConsoleUser)
+	for i, p := range m.providers {
+		cp, ok := p.(mdm.ConsoleUserProvider)
+		if !ok || len(bySource[m.names[i]]) == 0 {
+			continue
+		}
+		for serial, u := range cp.ConsoleUsers(bySource[m.names[i]]) {
+			res[serial] = u
+		}
+	}
+
+	return res
+}
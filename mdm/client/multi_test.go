@@ -0,0 +1,20 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/mdm/abm"
+)
+
+func TestMultiWriteBack(t *testing.T) {
+	m := &Multi{providers: []mdm.Provider{&abm.Client{}}, names: []mdm.MDM{mdm.ABM}}
+
+	for _, source := range []mdm.MDM{mdm.ABM, mdm.Jamf} {
+		err := m.WriteBack(mdm.Device{SerialNumber: "C02ABC123", Source: source}, mdm.Assignment{Manifest: "C02ABC123"})
+		if !errors.Is(err, mdm.ErrUnsupported) {
+			t.Errorf("Expected ErrUnsupported for %s, got %v", source, err)
+		}
+	}
+}
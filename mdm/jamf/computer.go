@@ -0,0 +1,55 @@
+package jamf
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/DataDog/jamf-api-client-go/classic"
+	"github.com/johnmikee/manifester/pkg/requester"
+)
+
+// computer is a computer record of the classic api. The api client does not
+// decode the model, processor or asset tag, so the record is requested
+// directly with the fields manifester uses.
+type computer struct {
+	Computer struct {
+		General struct {
+			ID           int    `json:"id"`
+			Name         string `json:"name"`
+			SerialNumber string `json:"serial_number"`
+			AssetTag     string `json:"asset_tag"`
+			Platform     string `json:"platform"`
+			ReportDate   string `json:"report_date"`
+		} `json:"general"`
+		Location classic.LocationInformation `json:"location"`
+		Hardware struct {
+			Model           string `json:"model"`
+			ModelIdentifier string `json:"model_identifier"`
+			OSVersion       string `json:"os_version"`
+			ProcessorType   string `json:"processor_type"`
+		} `json:"hardware"`
+	} `json:"computer"`
+}
+
+// computerDetails returns the computer with the id.
+func (c *Client) computerDetails(id int) (*computer, error) {
+	url := fmt.Sprintf("%s/computers/id/%d", c.client.Endpoint, id)
+	req, err := requester.New(http.MethodGet, "", url, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.client.Username, c.client.Password)
+	req.Header.Set("Accept", "application/json")
+
+	var res computer
+	resp, err := requester.Do(c.http, req, &res)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("getting computer %d: %s", id, resp.Status)
+	}
+
+	return &res, nil
+}
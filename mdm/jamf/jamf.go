@@ -1,8 +1,10 @@
 package jamf
 
 import (
+	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/DataDog/jamf-api-client-go/classic"
 	"github.com/johnmikee/manifester/mdm"
@@ -12,9 +14,20 @@ import (
 var wg sync.WaitGroup
 
 type Client struct {
-	log    logger.Logger
-	client *classic.Client
-	info   []mdm.MachineInfo
+	log       logger.Logger
+	client    *classic.Client
+	http      *http.Client
+	mu        sync.Mutex
+	info      []mdm.MachineInfo
+	attribute string
+}
+
+// Config is the jamf specific configuration passed as
+// mdm.Config.ProviderSpecificConfig.
+type Config struct {
+	// Attribute is the text extension attribute set by WriteBack, default
+	// Munki Manifest.
+	Attribute string
 }
 
 // Setup implements mdm.Provider.
@@ -24,13 +37,31 @@ func (c *Client) Setup(config mdm.Config) {
 	jc, err := classic.NewClient(config.URL,
 		config.User,
 		config.Password,
-		nil,
+		config.Client,
 	)
 	if err != nil {
 		config.Log.Fatal().AnErr("error", err).Msg("building jamf client")
 	}
 
 	c.client = jc
+	c.http = config.Client
+	c.attribute = "Munki Manifest"
+	if jc, ok := config.ProviderSpecificConfig.(*Config); ok && jc != nil && jc.Attribute != "" {
+		c.attribute = jc.Attribute
+	}
+}
+
+// WriteBack implements mdm.Writer by setting the extension attribute of the
+// computer to the manifest and departments.
+func (c *Client) WriteBack(d mdm.Device, a mdm.Assignment) error {
+	_, err := c.client.UpdateComputer(
+		&classic.ComputerIdentifier{ID: d.DeviceID},
+		&classic.ComputerDetails{
+			ExtensionAttributes: []classic.ExtensionAttribute{{Name: c.attribute, Value: a.String()}},
+		},
+	)
+
+	return err
 }
 
 func (c *Client) ListAllDevices() ([]mdm.MachineInfo, error) {
@@ -58,24 +89,40 @@ func (c *Client) ListAllDevices() ([]mdm.MachineInfo, error) {
 }
 
 func (c *Client) infoGrabber(ch chan classic.BasicComputerInfo, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	for v := range ch {
-		res, err := c.client.ComputerDetails(v.ID)
+		res, err := c.computerDetails(v.ID)
 		if err != nil {
-			c.log.Info().AnErr("error", err).Msg("getting computer details")
+			c.log.Info().AnErr("error", err).Int("id", v.ID).Msg("getting computer details")
+			continue
 		}
 
+		info := res.Computer
+		lastCheckIn, _ := time.Parse("2006-01-02 15:04:05", info.General.ReportDate)
+		c.mu.Lock()
 		c.info = append(c.info, mdm.MachineInfo{
 			Device: mdm.Device{
-				DeviceID:     strconv.Itoa(res.Info.ID),
-				Hostname:     res.Info.General.Name,
-				SerialNumber: res.Info.General.SerialNumber,
+				DeviceID:        strconv.Itoa(info.General.ID),
+				Hostname:        info.General.Name,
+				SerialNumber:    info.General.SerialNumber,
+				Model:           info.Hardware.Model,
+				ModelIdentifier: info.Hardware.ModelIdentifier,
+				OSVersion:       info.Hardware.OSVersion,
+				Platform:        info.General.Platform,
+				Architecture:    mdm.Architecture(info.Hardware.ModelIdentifier, info.Hardware.ProcessorType),
+				LastCheckIn:     lastCheckIn,
+				AssetTag:        info.General.AssetTag,
+				Source:          mdm.Jamf,
 			},
 			Users: &mdm.User{
-				Email: res.Info.UserLocation.EmailAddress,
-				Name:  res.Info.UserLocation.RealName,
-				ID:    res.Info.ID,
+				Email:      info.Location.EmailAddress,
+				Name:       info.Location.RealName,
+				ID:         info.General.ID,
+				Department: info.Location.Department,
+				Location:   info.Location.Building,
 			},
 		})
-		wg.Done()
+		c.mu.Unlock()
 	}
 }
@@ -0,0 +1,57 @@
+package jamf
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+func TestListAllDevices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/JSSResource/computers":
+			fmt.Fprint(w, `{"computers":[{"id":7,"name":"janes-mbp"}]}`)
+		case "/JSSResource/computers/id/7":
+			fmt.Fprint(w, `{"computer":{
+				"general":{"id":7,"name":"janes-mbp","serial_number":"C02ABC123","asset_tag":"IT-0042","platform":"Mac","report_date":"2024-05-01 09:30:00"},
+				"location":{"email_address":"jane@example.com","realname":"Jane Doe","department":"Engineering","building":"HQ"},
+				"hardware":{"model":"MacBook Pro (14-inch, 2021)","model_identifier":"MacBookPro18,3","os_version":"14.4.1","processor_type":"Apple M1 Pro"}
+			}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{}
+	c.Setup(mdm.Config{URL: srv.URL, User: "user", Password: "pass", Log: logger.Default()})
+
+	machines, err := c.ListAllDevices()
+	if err != nil {
+		t.Fatalf("ListAllDevices returned an error: %s", err)
+	}
+	if len(machines) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(machines))
+	}
+
+	d := machines[0].Device
+	if d.DeviceID != "7" || d.SerialNumber != "C02ABC123" || d.AssetTag != "IT-0042" {
+		t.Errorf("Expected the general section, got %+v", d)
+	}
+	if d.Model != "MacBook Pro (14-inch, 2021)" || d.ModelIdentifier != "MacBookPro18,3" || d.Architecture != mdm.ARM64 {
+		t.Errorf("Expected the hardware section, got %+v", d)
+	}
+	if u := machines[0].Users; u.Email != "jane@example.com" || u.Location != "HQ" {
+		t.Errorf("Expected the location section, got %+v", u)
+	}
+}
@@ -10,10 +10,30 @@ import (
 )
 
 type Client struct {
-	token   string
-	baseURL string
-	client  *http.Client
-	log     logger.Logger
+	token     string
+	baseURL   string
+	client    *http.Client
+	log       logger.Logger
+	details   bool
+	workers   int
+	tagPrefix string
+
+	blueprintDepartments bool
+}
+
+// Config is the kandji specific configuration passed as
+// mdm.Config.ProviderSpecificConfig.
+type Config struct {
+	// Details fetches the details of every device for the model identifier
+	// and architecture, which the device list does not include.
+	Details bool
+	// Workers is the number of concurrent details requests, default 5.
+	Workers int
+	// TagPrefix marks the tags set by WriteBack, default munki:.
+	TagPrefix string
+	// BlueprintDepartments reports the blueprint name of a device as the
+	// department of its user.
+	BlueprintDepartments bool
 }
 
 // Setup implements mdm.Provider.
@@ -22,6 +42,19 @@ func (c *Client) Setup(config mdm.Config) {
 	c.baseURL = helpers.URLShaper(config.URL, "api/v1/")
 	c.client = config.Client
 	c.log = logger.ChildLogger("kandji", &config.Log)
+	c.workers = 5
+	c.tagPrefix = "munki:"
+
+	if kc, ok := config.ProviderSpecificConfig.(*Config); ok && kc != nil {
+		c.details = kc.Details
+		c.blueprintDepartments = kc.BlueprintDepartments
+		if kc.Workers > 0 {
+			c.workers = kc.Workers
+		}
+		if kc.TagPrefix != "" {
+			c.tagPrefix = kc.TagPrefix
+		}
+	}
 }
 
 type offsetRange struct {
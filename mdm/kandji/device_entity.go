@@ -34,6 +34,7 @@ type DeviceResult struct {
 	FirstEnrollment string      `json:"first_enrollment"`
 	LastEnrollment  string      `json:"last_enrollment"`
 	BlueprintName   string      `json:"blueprint_name"`
+	Tags            []string    `json:"tags"`
 }
 
 // ActivationLock stores information on activation lock data on the device
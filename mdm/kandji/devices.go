@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/johnmikee/manifester/mdm"
 )
@@ -98,14 +100,30 @@ func (c *Client) ListAllDevices() ([]mdm.MachineInfo, error) {
 				DeviceID:     device.DeviceID,
 				Hostname:     device.DeviceName,
 				SerialNumber: device.SerialNumber,
+				Model:        device.Model,
+				OSVersion:    device.OSVersion,
+				Platform:     device.Platform,
+				Blueprint:    device.BlueprintName,
+				AssetTag:     assetTag(device.AssetTag),
+				Removed:      device.IsRemoved,
+				Missing:      device.IsMissing,
+				Source:       mdm.Kandji,
+				Tags:         device.Tags,
 			},
 		}
+		if device.LastCheckIn != nil {
+			m.Device.LastCheckIn = *device.LastCheckIn
+		}
 		if device.User != nil {
 			m.Users = &mdm.User{
 				Email: device.User.UserClass.Email,
 				Name:  device.User.UserClass.Name,
 				ID:    int(device.User.UserClass.ID),
 			}
+			// kandji has no departments, blueprints may be per team
+			if c.blueprintDepartments {
+				m.Users.Department = device.BlueprintName
+			}
 		} else {
 			m.Users = nil
 		}
@@ -113,9 +131,96 @@ func (c *Client) ListAllDevices() ([]mdm.MachineInfo, error) {
 		res = append(res, m)
 	}
 
+	if c.details {
+		c.addDetails(res)
+	}
+
 	return res, nil
 }
 
+// addDetails fills in the model identifier and architecture of each device
+// from its details.
+func (c *Client) addDetails(machines []mdm.MachineInfo) {
+	devices := make([]*mdm.Device, len(machines))
+	for i := range machines {
+		devices[i] = &machines[i].Device
+	}
+
+	c.eachDetails(devices, func(d *mdm.Device, details *DeviceDetails) {
+		hw := details.HardwareOverview
+		d.ModelIdentifier = hw.ModelIdentifier
+		d.Architecture = mdm.Architecture(hw.ModelIdentifier, hw.ProcessorName)
+		if d.Model == "" {
+			d.Model = hw.ModelName
+		}
+	})
+}
+
+// ConsoleUsers implements mdm.ConsoleUserProvider from the last user and the
+// regular users in the device details.
+func (c *Client) ConsoleUsers(devices []mdm.Device) map[string]mdm.ConsoleUser {
+	ptrs := make([]*mdm.Device, len(devices))
+	for i := range devices {
+		ptrs[i] = &devices[i]
+	}
+
+	var mu sync.Mutex
+	res := make(map[string]mdm.ConsoleUser)
+	c.eachDetails(ptrs, func(d *mdm.Device, details *DeviceDetails) {
+		u := mdm.ConsoleUser{LastUser: details.General.LastUser}
+		for _, account := range details.Users.RegularUsers {
+			u.Accounts = append(u.Accounts, account.Username)
+		}
+		mu.Lock()
+		res[d.SerialNumber] = u
+		mu.Unlock()
+	})
+
+	return res
+}
+
+// eachDetails fetches the details of the devices with at most c.workers
+// requests in flight and calls fn for each. Devices whose details cannot
+// be fetched are logged and skipped. fn must be safe for concurrent use.
+func (c *Client) eachDetails(devices []*mdm.Device, fn func(*mdm.Device, *DeviceDetails)) {
+	ch := make(chan *mdm.Device)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range ch {
+				details, err := c.deviceDetails(d.DeviceID)
+				if err != nil {
+					c.log.Info().AnErr("error", err).Str("serial", d.SerialNumber).Msg("getting device details")
+					continue
+				}
+				fn(d, details)
+			}
+		}()
+	}
+
+	for _, d := range devices {
+		ch <- d
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// assetTag returns the asset tag as a string, kandji returns null when unset.
+func assetTag(v interface{}) string {
+	switch tag := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return tag
+	case float64:
+		return strconv.FormatFloat(tag, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", tag)
+	}
+}
+
 func unmarshalDeviceResults(data []byte) (DeviceResults, error) {
 	var r DeviceResults
 	err := json.Unmarshal(data, &r)
@@ -0,0 +1,48 @@
+package kandji
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+func TestBlueprintDepartments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") != "0" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprint(w, `[{"device_id":"1","serial_number":"C02ABC123","blueprint_name":"Engineering",
+			"user":{"email":"jane@example.com","name":"Jane","id":1}}]`)
+	}))
+	defer srv.Close()
+
+	for _, enabled := range []bool{false, true} {
+		c := &Client{}
+		c.Setup(mdm.Config{
+			URL:                    srv.URL,
+			Token:                  "token",
+			Log:                    logger.Default(),
+			ProviderSpecificConfig: &Config{BlueprintDepartments: enabled},
+		})
+		devices, err := c.ListAllDevices()
+		if err != nil || len(devices) != 1 || devices[0].Users == nil {
+			t.Fatalf("Expected 1 device with a user, got %v: %v", devices, err)
+		}
+
+		expected := ""
+		if enabled {
+			expected = "Engineering"
+		}
+		if got := devices[0].Users.Department; got != expected {
+			t.Errorf("Expected department %q with blueprint departments %v, got %q", expected, enabled, got)
+		}
+		if devices[0].Device.Blueprint != "Engineering" {
+			t.Errorf("Expected the blueprint to be reported, got %q", devices[0].Device.Blueprint)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package kandji
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/johnmikee/manifester/pkg/requester"
+)
+
+// CustomProfile is a custom profile in the library.
+type CustomProfile struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Active     bool   `json:"active"`
+	RunsOnMac  bool   `json:"runs_on_mac"`
+	ProfileURL string `json:"profile_url"`
+}
+
+type customProfiles struct {
+	Count   int             `json:"count"`
+	Next    string          `json:"next"`
+	Results []CustomProfile `json:"results"`
+}
+
+// customProfile returns the custom profile with the name, or nil.
+func (c *Client) customProfile(name string) (*CustomProfile, error) {
+	url := "library/custom-profiles"
+	override := false
+	for url != "" {
+		req, err := c.newRequest(http.MethodGet, url, override, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var res customProfiles
+		resp, err := c.do(req, &res)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("kandji returned %s listing custom profiles", resp.Status)
+		}
+
+		for i := range res.Results {
+			if res.Results[i].Name == name {
+				return &res.Results[i], nil
+			}
+		}
+		url = res.Next
+		override = true
+	}
+
+	return nil, nil
+}
+
+// UploadProfile implements mdm.ProfileUploader. It creates a custom profile
+// that runs on macs or replaces the file of the custom profile with the same
+// name. The profile still has to be assigned to blueprints in kandji.
+func (c *Client) UploadProfile(name string, profile []byte) error {
+	existing, err := c.customProfile(name)
+	if err != nil {
+		c.log.Info().AnErr("error", err).Str("profile", name).Msg("listing custom profiles")
+		return err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fields := map[string]string{"name": name}
+	if existing == nil {
+		fields["active"] = "true"
+		fields["runs_on_mac"] = "true"
+	}
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	part, err := w.CreateFormFile("file", name+".mobileconfig")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(profile); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	method, url := http.MethodPost, "library/custom-profiles"
+	if existing != nil {
+		method, url = http.MethodPatch, fmt.Sprintf("library/custom-profiles/%s", existing.ID)
+	}
+	req, err := http.NewRequest(method, c.baseURL+url, &body)
+	if err != nil {
+		return err
+	}
+	c.headers(req)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := requester.Do(c.client, req, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return fmt.Errorf("kandji returned %s uploading custom profile %s", resp.Status, name)
+	}
+
+	c.log.Debug().Str("profile", name).Bool("created", existing == nil).Msg("uploaded custom profile")
+
+	return nil
+}
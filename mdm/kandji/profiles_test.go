@@ -0,0 +1,96 @@
+package kandji
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+// fakeLibrary serves the custom profiles of a kandji library.
+type fakeLibrary struct {
+	t        *testing.T
+	profiles map[string]string // id to name
+	files    map[string]string // id to profile
+	fields   map[string]string // form fields of the last upload
+}
+
+func (l *fakeLibrary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/library/custom-profiles":
+		fmt.Fprint(w, `{"next":null,"results":[`)
+		sep := ""
+		for id, name := range l.profiles {
+			fmt.Fprintf(w, `%s{"id":%q,"name":%q}`, sep, id, name)
+			sep = ","
+		}
+		fmt.Fprint(w, `]}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/library/custom-profiles":
+		l.upload(w, r, fmt.Sprintf("id-%d", len(l.profiles)+1))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPatch:
+		id := r.URL.Path[len("/api/v1/library/custom-profiles/"):]
+		if _, ok := l.profiles[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		l.upload(w, r, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (l *fakeLibrary) upload(w http.ResponseWriter, r *http.Request, id string) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		l.t.Fatalf("Error parsing form: %s", err)
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		l.t.Fatalf("Error reading file: %s", err)
+	}
+	data, _ := io.ReadAll(f)
+
+	l.fields = make(map[string]string)
+	for k, v := range r.MultipartForm.Value {
+		l.fields[k] = v[0]
+	}
+	l.profiles[id] = r.FormValue("name")
+	l.files[id] = string(data)
+}
+
+func TestUploadProfile(t *testing.T) {
+	library := &fakeLibrary{t: t, profiles: map[string]string{"other": "Wi-Fi"}, files: make(map[string]string)}
+	srv := httptest.NewServer(library)
+	defer srv.Close()
+
+	c := &Client{}
+	c.Setup(mdm.Config{URL: srv.URL, Token: "token", Log: logger.Default()})
+
+	if err := c.UploadProfile("Munki", []byte("first")); err != nil {
+		t.Fatalf("UploadProfile returned an error: %s", err)
+	}
+	if len(library.profiles) != 2 || library.files["id-2"] != "first" {
+		t.Fatalf("Expected a new custom profile, got %v", library.files)
+	}
+	if library.fields["runs_on_mac"] != "true" || library.fields["active"] != "true" {
+		t.Errorf("Expected the profile to be active on macs, got %v", library.fields)
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		if err := c.UploadProfile("Munki", []byte("second")); err != nil {
+			t.Fatalf("UploadProfile returned an error: %s", err)
+		}
+		if len(library.profiles) != 2 || library.files["id-2"] != "second" {
+			t.Errorf("Expected the custom profile to be replaced, got %v", library.files)
+		}
+	})
+}
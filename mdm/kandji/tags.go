@@ -0,0 +1,64 @@
+package kandji
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/johnmikee/manifester/mdm"
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+type updateDevice struct {
+	Tags []string `json:"tags"`
+}
+
+// WriteBack implements mdm.Writer. It tags the device with the manifest and
+// each department, replacing the tags from earlier runs. Tags without the
+// prefix are left alone.
+func (c *Client) WriteBack(d mdm.Device, a mdm.Assignment) error {
+	var tags []string
+	for _, t := range d.Tags {
+		if !strings.HasPrefix(t, c.tagPrefix) {
+			tags = append(tags, t)
+		}
+	}
+	tags = append(tags, c.tagPrefix+"manifest:"+a.Manifest)
+	for _, dept := range a.Departments {
+		tags = append(tags, c.tagPrefix+dept)
+	}
+
+	if sameTags(d.Tags, tags) {
+		c.log.Trace().Str("serial", d.SerialNumber).Msg("tags are current")
+		return nil
+	}
+
+	req, err := c.newRequest(http.MethodPatch, fmt.Sprintf("devices/%s", d.DeviceID), false, &updateDevice{Tags: tags})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return fmt.Errorf("kandji returned %s updating the tags of %s", resp.Status, d.SerialNumber)
+	}
+
+	return nil
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, t := range a {
+		if !helpers.Contains(b, t) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,7 +1,12 @@
 package mdm
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/johnmikee/manifester/pkg/logger"
 )
@@ -12,6 +17,9 @@ type MDM string
 const (
 	Jamf   MDM = "jamf"
 	Kandji MDM = "kandji"
+	// ABM is Apple Business Manager or Apple School Manager, it lists the
+	// devices of the organization before they enroll.
+	ABM MDM = "abm"
 )
 
 // Provider represents the interface for an MDM provider.
@@ -20,6 +28,74 @@ type Provider interface {
 	ListAllDevices() ([]MachineInfo, error)
 }
 
+// ConsoleUserProvider is implemented by providers that can report the local
+// accounts of a device. It is optional, callers check for it with a type
+// assertion.
+type ConsoleUserProvider interface {
+	// ConsoleUsers returns the local accounts of the devices keyed by serial
+	// number. Devices the provider could not look up are left out.
+	ConsoleUsers(devices []Device) map[string]ConsoleUser
+}
+
+// ErrUnsupported is returned by providers combining several mdms when the
+// mdm a device came from does not support the operation.
+var ErrUnsupported = errors.New("not supported by the mdm")
+
+// Writer is implemented by providers that can record what manifester
+// generated for a device on its record, so it is visible to anyone looking
+// at the device in the mdm. It is optional, callers check for it with a type
+// assertion.
+type Writer interface {
+	WriteBack(device Device, a Assignment) error
+}
+
+// ProfileUploader is implemented by providers that can install a
+// configuration profile on their devices, e.g. the munki preferences. It is
+// optional, callers check for it with a type assertion.
+type ProfileUploader interface {
+	// UploadProfile creates the profile with the name or replaces the one
+	// uploaded earlier.
+	UploadProfile(name string, profile []byte) error
+}
+
+// Assignment is what manifester generated for a device.
+type Assignment struct {
+	Manifest    string   // name of the manifest, the serial number
+	Departments []string // departments of the user
+}
+
+// String returns the manifest followed by the departments, e.g.
+// C02ABC123 (dept-eng, dept-ops).
+func (a Assignment) String() string {
+	if len(a.Departments) == 0 {
+		return a.Manifest
+	}
+
+	return a.Manifest + " (" + strings.Join(a.Departments, ", ") + ")"
+}
+
+// ConsoleUser holds the local accounts of a device.
+type ConsoleUser struct {
+	LastUser string   `json:"last_user"` // last user to log in
+	Accounts []string `json:"accounts"`  // regular, non system, accounts
+}
+
+// Primary returns the account most likely to belong to the user of the
+// device: the last user when it is a regular account, otherwise the only
+// regular account. It is empty when it cannot be told.
+func (u ConsoleUser) Primary() string {
+	for _, a := range u.Accounts {
+		if strings.EqualFold(a, u.LastUser) {
+			return a
+		}
+	}
+	if len(u.Accounts) == 1 {
+		return u.Accounts[0]
+	}
+
+	return ""
+}
+
 // Config is the struct that is used to configure the MDM client.
 type Config struct {
 	MDM                    MDM           `json:"mdm,omitempty"`
@@ -38,16 +114,128 @@ type MachineInfo struct {
 	Users  *User  `json:"users"`
 }
 
-// Device holds the general purpose information of the device
+// Device holds the general purpose information of the device. Fields the
+// provider does not report are left empty.
 type Device struct {
-	DeviceID     string `json:"device_id"`
-	Hostname     string `json:"host_name"`
-	SerialNumber string `json:"serial_number"`
+	DeviceID        string    `json:"device_id"`
+	Hostname        string    `json:"host_name"`
+	SerialNumber    string    `json:"serial_number"`
+	Model           string    `json:"model"`
+	ModelIdentifier string    `json:"model_identifier"`
+	OSVersion       string    `json:"os_version"`
+	Platform        string    `json:"platform"`
+	Architecture    string    `json:"architecture"`
+	LastCheckIn     time.Time `json:"last_check_in"`
+	Blueprint       string    `json:"blueprint"`
+	AssetTag        string    `json:"asset_tag"`
+	Removed         bool      `json:"removed"` // removed from the mdm but still listed
+	Missing         bool      `json:"missing"` // marked as lost or missing
+	Source          MDM       `json:"source"`  // provider that listed the device
+	Server          string    `json:"server"`  // mdm server assigned in apple business manager
+	Tags            []string  `json:"tags"`    // tags on the device record
+}
+
+// Precedence decides which record of a device listed by more than one
+// provider is kept.
+type Precedence string
+
+const (
+	// PrecedenceOrder keeps the record of the first provider.
+	PrecedenceOrder Precedence = "order"
+	// PrecedenceCheckIn keeps the record with the latest check in, the
+	// first provider wins a tie.
+	PrecedenceCheckIn Precedence = "check-in"
+)
+
+// Merge merges the device lists of several providers, given in order, by
+// serial number. When the kept record has no user the user of the other
+// record is used. Devices without a serial number are always kept.
+func Merge(lists [][]MachineInfo, p Precedence) []MachineInfo {
+	var res []MachineInfo
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, m := range list {
+			serial := strings.ToUpper(m.Device.SerialNumber)
+			i, ok := index[serial]
+			if serial == "" || !ok {
+				if serial != "" {
+					index[serial] = len(res)
+				}
+				res = append(res, m)
+				continue
+			}
+
+			kept := &res[i]
+			if p == PrecedenceCheckIn && m.Device.LastCheckIn.After(kept.Device.LastCheckIn) {
+				m, *kept = *kept, m
+			}
+			if !kept.hasUser() && m.hasUser() {
+				kept.Users = m.Users
+			}
+		}
+	}
+
+	return res
+}
+
+func (m *MachineInfo) hasUser() bool {
+	return m.Users != nil && m.Users.Email != ""
+}
+
+// Architectures reported in Device.Architecture.
+const (
+	ARM64 = "arm64"
+	X8664 = "x86_64"
+)
+
+// appleSilicon are the model identifier families of the first Apple silicon
+// Macs. Every Mac with a MacXX,Y identifier is Apple silicon.
+var appleSilicon = map[string]int{
+	"MacBookAir": 10,
+	"MacBookPro": 17,
+	"Macmini":    9,
+	"iMac":       21,
+}
+
+// Architecture returns the CPU architecture of a Mac from its processor
+// name or model identifier, or an empty string when it cannot be told.
+func Architecture(modelIdentifier, processor string) string {
+	switch {
+	case strings.HasPrefix(processor, "Apple"):
+		return ARM64
+	case strings.HasPrefix(processor, "Intel"):
+		return X8664
+	}
+
+	family := strings.TrimRightFunc(modelIdentifier, func(r rune) bool {
+		return unicode.IsDigit(r) || r == ','
+	})
+	major, err := strconv.Atoi(strings.Split(strings.TrimPrefix(modelIdentifier, family), ",")[0])
+	if family == "" || err != nil {
+		return ""
+	}
+	if family == "Mac" {
+		return ARM64
+	}
+	if first, ok := appleSilicon[family]; ok {
+		if major >= first {
+			return ARM64
+		}
+		return X8664
+	}
+	if strings.HasPrefix(family, "Mac") || strings.HasPrefix(family, "iMac") {
+		return X8664
+	}
+
+	return ""
 }
 
-// User holds the general purpose information of the user
+// User holds the general purpose information of the user. Department and
+// Location are set by providers that record them.
 type User struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
-	ID    int    `json:"id"`
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	ID         int    `json:"id"`
+	Department string `json:"department,omitempty"`
+	Location   string `json:"location,omitempty"`
 }
@@ -0,0 +1,100 @@
+package mdm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchitecture(t *testing.T) {
+	tests := []struct {
+		identifier string
+		processor  string
+		expected   string
+	}{
+		{"", "Apple M1 Pro", ARM64},
+		{"", "Intel Core i7", X8664},
+		{"Mac14,2", "", ARM64},
+		{"MacBookPro18,3", "", ARM64},
+		{"MacBookPro16,1", "", X8664},
+		{"MacBookAir10,1", "", ARM64},
+		{"Macmini8,1", "", X8664},
+		{"iMac21,1", "", ARM64},
+		{"MacPro7,1", "", X8664},
+		{"iPhone15,2", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.identifier+tt.processor, func(t *testing.T) {
+			if got := Architecture(tt.identifier, tt.processor); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestConsoleUserPrimary(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     ConsoleUser
+		expected string
+	}{
+		{"last user", ConsoleUser{LastUser: "jane", Accounts: []string{"admin", "jane"}}, "jane"},
+		{"last user case", ConsoleUser{LastUser: "Jane", Accounts: []string{"jane"}}, "jane"},
+		{"single account", ConsoleUser{LastUser: "root", Accounts: []string{"jane"}}, "jane"},
+		{"ambiguous", ConsoleUser{Accounts: []string{"admin", "jane"}}, ""},
+		{"none", ConsoleUser{LastUser: "jane"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.Primary(); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+	kandji := []MachineInfo{
+		{Device: Device{SerialNumber: "C02ABC123", Source: Kandji, LastCheckIn: now.Add(-time.Hour)}},
+		{Device: Device{SerialNumber: "C02KANDJI", Source: Kandji}},
+	}
+	jamf := []MachineInfo{
+		{Device: Device{SerialNumber: "c02abc123", Source: Jamf, LastCheckIn: now}, Users: &User{Email: "jane@example.com"}},
+		{Device: Device{SerialNumber: "C02JAMF01", Source: Jamf}},
+		{Device: Device{Source: Jamf}},
+	}
+
+	tests := []struct {
+		precedence Precedence
+		source     MDM
+	}{
+		{PrecedenceOrder, Kandji},
+		{PrecedenceCheckIn, Jamf},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.precedence), func(t *testing.T) {
+			res := Merge([][]MachineInfo{kandji, jamf}, tt.precedence)
+			if len(res) != 4 {
+				t.Fatalf("Expected 4 devices, got %d", len(res))
+			}
+			if res[0].Device.Source != tt.source {
+				t.Errorf("Expected the %s record, got %s", tt.source, res[0].Device.Source)
+			}
+			if res[0].Users == nil || res[0].Users.Email != "jane@example.com" {
+				t.Errorf("Expected the user from jamf, got %v", res[0].Users)
+			}
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	a := Assignment{Manifest: "C02ABC123"}
+	if a.String() != "C02ABC123" {
+		t.Errorf("Expected only the manifest, got %s", a.String())
+	}
+	a.Departments = []string{"dept-eng", "dept-ops"}
+	if a.String() != "C02ABC123 (dept-eng, dept-ops)" {
+		t.Errorf("Expected the manifest and departments, got %s", a.String())
+	}
+}
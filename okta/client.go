@@ -1,11 +1,13 @@
 package okta
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/johnmikee/manifester/pkg/helpers"
 	"github.com/johnmikee/manifester/pkg/logger"
+	"github.com/johnmikee/manifester/pkg/oauth"
 	"github.com/johnmikee/manifester/pkg/requester"
 )
 
@@ -16,27 +18,47 @@ type Client struct {
 	domain     string
 	blockGroup string
 	client     *http.Client
+	tokens     *oauth.TokenSource
 	log        logger.Logger
 }
 
 // Config represents the configuration for the Okta client.
+//
+// When ClientID is set the client authenticates as an OAuth 2.0 service app
+// with a private_key_jwt assertion signed by PrivateKey instead of using
+// the SSWS API Token.
 type Config struct {
-	Domain string         `json:"domain,omitempty"`
-	URL    string         `json:"url,omitempty"`
-	Token  string         `json:"token,omitempty"`
-	Client *http.Client   `json:"client,omitempty"`
-	Log    *logger.Logger `json:"log,omitempty"`
+	Domain     string         `json:"domain,omitempty"`
+	URL        string         `json:"url,omitempty"`
+	Token      string         `json:"token,omitempty"`
+	ClientID   string         `json:"client_id,omitempty"`
+	PrivateKey []byte         `json:"private_key,omitempty"` // PEM encoded RSA or EC key
+	KeyID      string         `json:"key_id,omitempty"`
+	Scopes     []string       `json:"scopes,omitempty"`
+	Client     *http.Client   `json:"client,omitempty"`
+	Log        *logger.Logger `json:"log,omitempty"`
 }
 
 // New returns a pointer with the Client after validating the arguments passed.
 func New(c *Config) *Client {
-	return &Client{
-		token:   helpers.TokenValidator(c.Token, "SSWS"),
+	client := &Client{
 		baseURL: helpers.URLShaper(c.URL, "api/v1/"),
 		domain:  c.Domain,
 		client:  c.Client,
 		log:     logger.ChildLogger("okta", c.Log),
 	}
+
+	if c.ClientID == "" {
+		client.token = helpers.TokenValidator(c.Token, "SSWS")
+		return client
+	}
+
+	client.tokens = newTokenSource(c, helpers.URLShaper(c.URL, ""))
+	if err := client.tokens.Err(); err != nil {
+		client.log.Error().Err(err).Msg("failed to parse okta private key")
+	}
+
+	return client
 }
 
 func (o *Client) newRequest(method, url string, override bool, body interface{}) (*http.Request, error) {
@@ -53,16 +75,78 @@ func (o *Client) headers(req *http.Request) *http.Request {
 
 func (o *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	o.headers(req)
-	return requester.Do(o.client, req, v)
+
+	if o.tokens != nil {
+		token, err := o.tokens.Token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := requester.Do(o.client, req, v)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && o.tokens != nil {
+		// the token may have been revoked, fetch a new one on the next request
+		o.tokens.Reset()
+	}
+
+	return resp, err
+}
+
+// paginate requests url and follows the Link rel="next" header until every
+// page has been decoded, returning the combined results.
+//   - https://developer.okta.com/docs/reference/core-okta-api/#pagination
+func paginate[T any](o *Client, url string) ([]T, error) {
+	var override bool
+
+	res := []T{}
+	for {
+		req, err := o.newRequest(http.MethodGet, url, override, nil)
+		if err != nil {
+			o.log.Error().Err(err).Msg("error creating request")
+			return nil, err
+		}
+
+		var page []T
+		resp, err := o.do(req, &page)
+		if err != nil {
+			o.log.Error().Err(err).Msg("error making request")
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("okta returned %s for %s", resp.Status, req.URL.Path)
+		}
+
+		res = append(res, page...)
+
+		link := linkSorter(resp.Header["Link"])
+		if link == "" {
+			o.log.Trace().Msg("no more responses from okta")
+			break
+		}
+
+		url = link
+		override = true
+		o.log.Trace().Msg("checking next link..")
+	}
+
+	return res, nil
 }
 
 func linkSorter(l []string) string {
 	var link string
 	for _, i := range l {
 		res := strings.Split(i, ";")
+		if len(res) < 2 {
+			continue
+		}
 		check := strings.Split(res[1], "=")
+		if len(check) < 2 {
+			continue
+		}
 		if strings.Contains(check[1], "next") {
-			link = res[0]
+			link = strings.TrimSpace(res[0])
 		}
 	}
 	link = strings.ReplaceAll(link, "<", "")
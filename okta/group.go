@@ -2,25 +2,68 @@ package okta
 
 import (
 	"fmt"
-	"net/http"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/pkg/requester"
 )
 
 type Groups []Group
 
-type Group []struct {
-	ID                    string       `json:"id"`
-	Created               time.Time    `json:"created"`
-	LastUpdated           time.Time    `json:"lastUpdated"`
-	LastMembershipUpdated time.Time    `json:"lastMembershipUpdated"`
-	ObjectClass           []string     `json:"objectClass"`
-	Type                  string       `json:"type"`
-	Profile               GroupProfile `json:"profile,omitempty"`
-	Links                 Links        `json:"_links,omitempty"`
-	Source                Source       `json:"source,omitempty"`
+type Group struct {
+	ID                    string         `json:"id"`
+	Created               time.Time      `json:"created"`
+	LastUpdated           time.Time      `json:"lastUpdated"`
+	LastMembershipUpdated time.Time      `json:"lastMembershipUpdated"`
+	ObjectClass           []string       `json:"objectClass"`
+	Type                  string         `json:"type"`
+	Profile               GroupProfile   `json:"profile,omitempty"`
+	Links                 Links          `json:"_links,omitempty"`
+	Source                Source         `json:"source,omitempty"`
+	Embedded              *GroupEmbedded `json:"_embedded,omitempty"`
+}
+
+// GroupEmbedded holds the resources embedded when listing groups with expand.
+type GroupEmbedded struct {
+	Stats *GroupStats `json:"stats,omitempty"`
+}
+
+// GroupStats holds the counts returned with expand=stats.
+type GroupStats struct {
+	UsersCount             int  `json:"usersCount"`
+	AppsCount              int  `json:"appsCount"`
+	GroupPushMappingsCount int  `json:"groupPushMappingsCount"`
+	HasAdminPrivilege      bool `json:"hasAdminPrivilege"`
+}
+
+// ListGroupsOptions are the optional parameters used when listing groups.
+type ListGroupsOptions struct {
+	// Stats expands the group stats so groups without members can be
+	// skipped without requesting their members.
+	Stats bool
+	// Search is an okta search expression passed to /groups?search=
+	//   - https://developer.okta.com/docs/reference/api/groups/#list-groups-with-search
+	Search string
 }
 
+// GroupFilter selects which groups are returned when mapping group names.
+// Every condition that is set must match.
+type GroupFilter struct {
+	// Prefix the group name must start with.
+	Prefix string
+	// Include requires the group name to match at least one expression.
+	Include []*regexp.Regexp
+	// Exclude drops groups whose name matches any expression.
+	Exclude []*regexp.Regexp
+	// Types limits the group type, e.g. OKTA_GROUP, APP_GROUP or BUILT_IN.
+	Types []string
+}
+
+// InactiveStatuses are the user statuses skipped when listing group members.
+var InactiveStatuses = []string{"DEPROVISIONED", "SUSPENDED"}
+
 type GroupProfile struct {
 	Name           string `json:"name,omitempty"`
 	Description    string `json:"description,omitempty"`
@@ -32,7 +75,9 @@ type GroupProfile struct {
 	ExternalID     string `json:"externalId,omitempty"`
 }
 
-type GroupMembers []struct {
+type GroupMembers []GroupMember
+
+type GroupMember struct {
 	ID            string    `json:"id"`
 	Status        string    `json:"status"`
 	Created       time.Time `json:"created"`
@@ -63,18 +108,23 @@ type Links struct {
 
 var groupBase = "groups"
 
-// GetMembers returns a map of group names to a slice of email addresses
-func (g Groups) GetMembers(o *Client, filter *string) map[string][]string {
+// GetMembers returns a map of group names to a slice of email addresses.
+// Members that are deprovisioned or suspended are skipped.
+func (g Groups) GetMembers(o *Client, filter *GroupFilter) map[string][]string {
 	m := make(map[string][]string)
 	idNameMap := g.idNameMap(filter)
 
 	for group, name := range idNameMap {
 		gr, err := o.getGroupsMembers(group)
 		if err != nil {
-			o.log.Error().Err(err).Msg("error getting group members")
+			o.log.Error().Err(err).Str("group", name).Msg("error getting group members")
 			continue
 		}
 		for _, member := range gr {
+			if helpers.Contains(InactiveStatuses, member.Status) {
+				o.log.Trace().Str("user", member.Profile.Email).Str("status", member.Status).Msg("skipping inactive member")
+				continue
+			}
 			m[name] = append(m[name], member.Profile.Email)
 		}
 	}
@@ -83,85 +133,102 @@ func (g Groups) GetMembers(o *Client, filter *string) map[string][]string {
 }
 
 func (o *Client) getGroupsMembers(groupID string) (GroupMembers, error) {
-	req, err := o.newRequest(http.MethodGet, fmt.Sprintf("%s/%s/users", groupBase, groupID), false, nil)
-	if err != nil {
-		return nil, err
+	return paginate[GroupMember](o, fmt.Sprintf("%s/%s/users", groupBase, groupID))
+}
+
+// ListGroups queries the groups endpoint and paginates until all groups have been returned.
+func (o *Client) ListGroups(opts *ListGroupsOptions) (Groups, error) {
+	params := requester.Params{}
+	if opts != nil {
+		if opts.Stats {
+			params["expand"] = "stats"
+		}
+		params["search"] = opts.Search
 	}
 
-	var groupMembers GroupMembers
-	_, err = o.do(req, &groupMembers)
+	url, err := requester.BuildQuery(
+		&requester.Query{
+			Endpoint: groupBase,
+			Params:   params,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return groupMembers, nil
+	return paginate[Group](o, url)
 }
 
-// ListGroups queries the groups endpoint and paginates until all groups have been returned.
-func (o *Client) ListGroups() (Groups, error) {
-	var override bool
-	var group Group
-
-	groups := Groups{}
-	url := groupBase
-	for {
-		resp, err := o.listGroups(url, override, &group)
-		if err != nil {
-			return nil, err
-		}
-
-		groups = append(groups, group)
-		value := resp.Header["Link"]
+// MakeIDNameMap returns a map of group IDs to group names with
+// an optional filter.
+func (g Groups) MakeIDNameMap(filter *GroupFilter) map[string]string {
+	return g.idNameMap(filter)
+}
 
-		link := linkSorter(value)
-		if link == "" {
-			o.log.Trace().Msg("no more responses from okta")
-			break
+// idNameMap maps group IDs to names. Groups listed with stats that
+// have no members are skipped.
+func (g Groups) idNameMap(f *GroupFilter) map[string]string {
+	m := make(map[string]string)
+	for i := range g {
+		group := &g[i]
+		if !f.Match(group) {
+			continue
 		}
-
-		url = link
-		override = true
-		o.log.Trace().Msg("checking next link..")
+		if group.empty() {
+			continue
+		}
+		m[group.ID] = group.Profile.Name
 	}
 
-	return groups, nil
+	return m
 }
 
-func (o *Client) listGroups(url string, override bool, group *Group) (*http.Response, error) {
-	req, err := o.newRequest(http.MethodGet, url, override, nil)
-	if err != nil {
-		o.log.Error().Err(err).Msg("error creating request")
-		return nil, err
-	}
+// empty is true when the group was listed with stats and has no members.
+func (g *Group) empty() bool {
+	return g.Embedded != nil && g.Embedded.Stats != nil && g.Embedded.Stats.UsersCount == 0
+}
 
-	resp, err := o.do(req, group)
-	if err != nil {
-		o.log.Error().Err(err).Msg("error making request")
-		return nil, err
+// Match reports whether the group passes the filter. A nil filter matches
+// every group.
+func (f *GroupFilter) Match(g *Group) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Types) > 0 && !helpers.Contains(f.Types, g.Type) {
+		return false
 	}
 
-	return resp, nil
+	return f.MatchName(g.Profile.Name)
 }
 
-// MakeIDNameMap returns a map of group IDs to group names with
-// an optional filter. right now this is a simple strings.hasPrefix
-// check on the group name.
-func (g Groups) MakeIDNameMap(filter *string) map[string]string {
-	return g.idNameMap(filter)
-}
+// MatchName reports whether the group name passes the prefix and
+// regular expression conditions of the filter.
+func (f *GroupFilter) MatchName(name string) bool {
+	if f == nil {
+		return true
+	}
+	if !strings.HasPrefix(name, f.Prefix) {
+		return false
+	}
 
-func (g Groups) idNameMap(f *string) map[string]string {
-	m := make(map[string]string)
-	for _, groups := range g {
-		for group := range groups {
-			if f != nil {
-				if !strings.HasPrefix(groups[group].Profile.Name, *f) {
-					continue
-				}
+	if len(f.Include) > 0 {
+		included := false
+		for _, re := range f.Include {
+			if re.MatchString(name) {
+				included = true
+				break
 			}
-			m[groups[group].ID] = groups[group].Profile.Name
+		}
+		if !included {
+			return false
 		}
 	}
 
-	return m
+	for _, re := range f.Exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	return true
 }
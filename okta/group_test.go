@@ -0,0 +1,84 @@
+package okta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestGetMembers(t *testing.T) {
+	var srv *httptest.Server
+	var expand string
+	requested := map[string]bool{}
+	client, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/groups":
+			expand = r.URL.Query().Get("expand")
+			fmt.Fprint(w, `[
+				{"id":"g1","type":"OKTA_GROUP","profile":{"name":"dept-eng"},"_embedded":{"stats":{"usersCount":3}}},
+				{"id":"g2","type":"OKTA_GROUP","profile":{"name":"dept-empty"},"_embedded":{"stats":{"usersCount":0}}},
+				{"id":"g3","type":"OKTA_GROUP","profile":{"name":"other"},"_embedded":{"stats":{"usersCount":1}}}
+			]`)
+		case "/api/v1/groups/g1/users":
+			requested["g1"] = true
+			if r.URL.Query().Get("after") == "" {
+				w.Header().Add("Link", fmt.Sprintf(`<%s/api/v1/groups/g1/users>; rel="self"`, srv.URL))
+				w.Header().Add("Link", fmt.Sprintf(`<%s/api/v1/groups/g1/users?after=2>; rel="next"`, srv.URL))
+				fmt.Fprint(w, `[{"id":"1","status":"ACTIVE","profile":{"email":"a@example.com"}},{"id":"2","status":"ACTIVE","profile":{"email":"b@example.com"}},{"id":"4","status":"DEPROVISIONED","profile":{"email":"gone@example.com"}}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"id":"3","status":"ACTIVE","profile":{"email":"c@example.com"}},{"id":"5","status":"SUSPENDED","profile":{"email":"away@example.com"}}]`)
+		default:
+			requested[r.URL.Path] = true
+			fmt.Fprint(w, `[]`)
+		}
+	})
+
+	groups, err := client.ListGroups(&ListGroupsOptions{Stats: true})
+	if err != nil {
+		t.Fatalf("ListGroups returned an error: %s", err)
+	}
+	if expand != "stats" {
+		t.Errorf("Expected expand=stats, got %q", expand)
+	}
+
+	members := groups.GetMembers(client, &GroupFilter{Prefix: "dept"})
+	sort.Strings(members["dept-eng"])
+
+	expected := map[string][]string{"dept-eng": {"a@example.com", "b@example.com", "c@example.com"}}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("Expected %v, got %v", expected, members)
+	}
+	if len(requested) != 1 {
+		t.Errorf("Expected only the non-empty filtered group to be requested, got %v", requested)
+	}
+}
+
+func TestGroupFilter(t *testing.T) {
+	groups := Groups{
+		{ID: "1", Type: "OKTA_GROUP", Profile: GroupProfile{Name: "dept-eng"}},
+		{ID: "2", Type: "APP_GROUP", Profile: GroupProfile{Name: "dept-sales"}},
+		{ID: "3", Type: "OKTA_GROUP", Profile: GroupProfile{Name: "dept-eng-contractors"}},
+		{ID: "4", Type: "BUILT_IN", Profile: GroupProfile{Name: "Everyone"}},
+		{ID: "5", Type: "OKTA_GROUP", Profile: GroupProfile{Name: "team-design"}},
+	}
+
+	filter := &GroupFilter{
+		Include: []*regexp.Regexp{regexp.MustCompile(`^dept-`), regexp.MustCompile(`^team-`)},
+		Exclude: []*regexp.Regexp{regexp.MustCompile(`-contractors$`)},
+		Types:   []string{"OKTA_GROUP"},
+	}
+
+	expected := map[string]string{"1": "dept-eng", "5": "team-design"}
+	if m := groups.MakeIDNameMap(filter); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+
+	if m := groups.MakeIDNameMap(nil); len(m) != len(groups) {
+		t.Errorf("Expected a nil filter to match every group, got %v", m)
+	}
+}
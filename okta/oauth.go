@@ -0,0 +1,28 @@
+package okta
+
+import (
+	"github.com/johnmikee/manifester/pkg/oauth"
+)
+
+// DefaultScopes are requested when no scopes are configured for an OAuth service app.
+var DefaultScopes = []string{"okta.groups.read", "okta.users.read"}
+
+// newTokenSource returns the token source of an Okta service app, the client
+// assertion is addressed to the token endpoint of the org.
+//   - https://developer.okta.com/docs/guides/implement-oauth-for-okta-serviceapp/main/
+func newTokenSource(c *Config, orgURL string) *oauth.TokenSource {
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	return oauth.NewTokenSource(&oauth.Config{
+		Name:       "okta",
+		ClientID:   c.ClientID,
+		KeyID:      c.KeyID,
+		PrivateKey: c.PrivateKey,
+		Scopes:     scopes,
+		TokenURL:   orgURL + "oauth2/v1/token",
+		Client:     c.Client,
+	})
+}
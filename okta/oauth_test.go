@@ -0,0 +1,127 @@
+package okta
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOAuthServiceApp(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	var tokenRequests int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/v1/token":
+			tokenRequests++
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("Error parsing form: %s", err)
+			}
+			if r.Form.Get("grant_type") != "client_credentials" {
+				t.Errorf("Unexpected grant_type %s", r.Form.Get("grant_type"))
+			}
+			if r.Form.Get("scope") != "okta.groups.read okta.users.read" {
+				t.Errorf("Unexpected scope %s", r.Form.Get("scope"))
+			}
+			if r.Form.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+				t.Errorf("Unexpected client_assertion_type %s", r.Form.Get("client_assertion_type"))
+			}
+
+			claims := decodeClaims(t, r.Form.Get("client_assertion"))
+			if claims["iss"] != "client-id" || claims["sub"] != "client-id" {
+				t.Errorf("Unexpected iss/sub in assertion %v", claims)
+			}
+			if claims["aud"] != srv.URL+"/oauth2/v1/token" {
+				t.Errorf("Unexpected aud in assertion %v", claims["aud"])
+			}
+
+			fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":3600}`, tokenRequests)
+		case "/api/v1/groups":
+			if r.Header.Get("Authorization") != fmt.Sprintf("Bearer access-%d", tokenRequests) {
+				t.Errorf("Unexpected authorization header %s", r.Header.Get("Authorization"))
+			}
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+
+	client := New(
+		&Config{
+			URL:        srv.URL,
+			ClientID:   "client-id",
+			PrivateKey: pemKey,
+			KeyID:      "kid",
+			Log:        &log,
+			Client:     srv.Client(),
+		},
+	)
+
+	now := time.Now()
+	client.tokens.Now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListGroups(nil); err != nil {
+			t.Fatalf("ListGroups returned an error: %s", err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("Expected the token to be cached, got %d token requests", tokenRequests)
+	}
+
+	// move to within the refresh window
+	now = now.Add(58 * time.Minute)
+	if _, err := client.ListGroups(nil); err != nil {
+		t.Fatalf("ListGroups returned an error: %s", err)
+	}
+	if tokenRequests != 2 {
+		t.Errorf("Expected the token to be refreshed before expiry, got %d token requests", tokenRequests)
+	}
+}
+
+func TestOAuthInvalidKey(t *testing.T) {
+	client := New(
+		&Config{
+			URL:        "https://example.okta.com",
+			ClientID:   "client-id",
+			PrivateKey: []byte("not a key"),
+			Log:        &log,
+		},
+	)
+
+	if _, err := client.ListGroups(nil); err == nil || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("Expected a private key error, got %v", err)
+	}
+}
+
+func decodeClaims(t *testing.T, token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a compact JWS, got %s", token)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Error decoding claims: %s", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatalf("Error unmarshaling claims: %s", err)
+	}
+
+	return claims
+}
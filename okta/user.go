@@ -1,7 +1,12 @@
 package okta
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/johnmikee/manifester/pkg/requester"
 )
 
 // UserResponse holds information on the user returned when querying the /users endpoint
@@ -17,6 +22,9 @@ type User struct {
 	Profile         Profile   `json:"profile"`
 }
 
+// Users is a list of User
+type Users []User
+
 // Profiles holds information on the users profile
 type Profile struct {
 	LastName    string `json:"lastName"`
@@ -30,4 +38,61 @@ type Profile struct {
 	Department  string `json:"department"`
 	StartDate   string `json:"startDate"`
 	Email       string `json:"email"`
+
+	// attributes holds every attribute on the profile, including custom
+	// attributes that are not modeled above.
+	attributes map[string]interface{}
+}
+
+// UnmarshalJSON decodes the known attributes into the struct and keeps
+// the full profile so any attribute can be looked up with Attribute.
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	type profile Profile
+	var pp profile
+	if err := json.Unmarshal(data, &pp); err != nil {
+		return err
+	}
+	*p = Profile(pp)
+
+	return json.Unmarshal(data, &p.attributes)
+}
+
+// Attribute returns the value of the profile attribute as a string, e.g.
+// department, costCenter or a custom attribute. An empty string is
+// returned if the attribute is not set.
+func (p Profile) Attribute(name string) string {
+	v, ok := p.attributes[name]
+	if !ok || v == nil {
+		return ""
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+var userBase = "users"
+
+// ListUsers queries the users endpoint with an optional search expression
+// and paginates until all users have been returned.
+//   - https://developer.okta.com/docs/reference/api/users/#list-users-with-search
+func (o *Client) ListUsers(search string) (Users, error) {
+	url, err := requester.BuildQuery(
+		&requester.Query{
+			Endpoint: userBase,
+			Params: requester.Params{
+				"search": search,
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate[User](o, url)
 }
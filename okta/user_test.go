@@ -0,0 +1,72 @@
+package okta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+var log = logger.NewLogger(
+	&logger.Config{
+		ToFile:  false,
+		Level:   logger.DEBUG,
+		Service: "test",
+		Env:     "dev",
+	},
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *httptest.Server) {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return New(
+		&Config{
+			URL:    srv.URL,
+			Token:  "token",
+			Log:    &log,
+			Client: srv.Client(),
+		},
+	), srv
+}
+
+func TestListUsers(t *testing.T) {
+	var search string
+	var srv *httptest.Server
+	client, srv := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "SSWS token" {
+			t.Errorf("Expected SSWS authorization, got %s", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Query().Get("after") {
+		case "":
+			search = r.URL.Query().Get("search")
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/users?after=1>; rel="next"`, srv.URL))
+			fmt.Fprint(w, `[{"id":"1","status":"ACTIVE","profile":{"email":"a@example.com","department":"Eng","costCenter":"1234567"}}]`)
+		default:
+			fmt.Fprint(w, `[{"id":"2","status":"ACTIVE","profile":{"email":"b@example.com","department":"Sales"}}]`)
+		}
+	})
+
+	users, err := client.ListUsers(`profile.department eq "Eng"`)
+	if err != nil {
+		t.Fatalf("ListUsers returned an error: %s", err)
+	}
+
+	if search != `profile.department eq "Eng"` {
+		t.Errorf("Expected search expression to be passed, got %q", search)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 users across pages, got %d", len(users))
+	}
+	if users[0].Profile.Department != "Eng" {
+		t.Errorf("Expected department Eng, got %s", users[0].Profile.Department)
+	}
+	if cc := users[0].Profile.Attribute("costCenter"); cc != "1234567" {
+		t.Errorf("Expected costCenter attribute 1234567, got %s", cc)
+	}
+	if missing := users[1].Profile.Attribute("costCenter"); missing != "" {
+		t.Errorf("Expected missing attribute to be empty, got %s", missing)
+	}
+}
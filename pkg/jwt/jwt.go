@@ -0,0 +1,136 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// ParsePrivateKey parses a PEM encoded RSA or EC private key in PKCS1,
+// PKCS8 or SEC1 form.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unable to parse private key, expected an RSA or EC key")
+}
+
+// Algorithm returns the JWS algorithm used to sign with the key.
+//
+// RSA keys use RS256, EC keys use ES256 or ES384 depending on the curve.
+func Algorithm(key crypto.Signer) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// Sign returns the compact serialization of claims signed with key. When
+// kid is not empty it is added to the header so the verifier can select
+// the matching public key.
+func Sign(claims interface{}, key crypto.Signer, kid string) (string, error) {
+	alg, err := Algorithm(key)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{
+		"alg": alg,
+		"typ": "JWT",
+	}
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(c)
+
+	var hasher hash.Hash
+	var hashFunc crypto.Hash
+	switch alg {
+	case "ES384":
+		hasher, hashFunc = sha512.New384(), crypto.SHA384
+	default:
+		hasher, hashFunc = sha256.New(), crypto.SHA256
+	}
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, hashFunc, digest)
+		if err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed width r || s form rather than ASN.1
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(pad(r, size), pad(s, size)...)
+	}
+
+	return signingInput + "." + encode(sig), nil
+}
+
+func pad(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating rsa key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ec key: %s", err)
+	}
+
+	claims := map[string]string{"iss": "client"}
+
+	t.Run("RS256", func(t *testing.T) {
+		token, err := Sign(claims, rsaKey, "kid-1")
+		if err != nil {
+			t.Fatalf("Sign returned an error: %s", err)
+		}
+		parts := strings.Split(token, ".")
+		header := decodeHeader(t, parts[0])
+		if header["alg"] != "RS256" || header["kid"] != "kid-1" {
+			t.Errorf("Unexpected header %v", header)
+		}
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if err := rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("Signature did not verify: %s", err)
+		}
+	})
+
+	t.Run("ES256", func(t *testing.T) {
+		token, err := Sign(claims, ecKey, "")
+		if err != nil {
+			t.Fatalf("Sign returned an error: %s", err)
+		}
+		parts := strings.Split(token, ".")
+		header := decodeHeader(t, parts[0])
+		if header["alg"] != "ES256" {
+			t.Errorf("Expected ES256, got %s", header["alg"])
+		}
+		if _, ok := header["kid"]; ok {
+			t.Errorf("Expected no kid in header")
+		}
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		if len(sig) != 64 {
+			t.Fatalf("Expected 64 byte signature, got %d", len(sig))
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(&ecKey.PublicKey, digest[:], r, s) {
+			t.Errorf("Signature did not verify")
+		}
+	})
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sec1, _ := x509.MarshalECPrivateKey(ecKey)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	for name, block := range map[string]*pem.Block{
+		"SEC1":  {Type: "EC PRIVATE KEY", Bytes: sec1},
+		"PKCS8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		key, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("%s: ParsePrivateKey returned an error: %s", name, err)
+			continue
+		}
+		if _, ok := key.(*ecdsa.PrivateKey); !ok {
+			t.Errorf("%s: expected an ecdsa key, got %T", name, key)
+		}
+	}
+
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("Expected an error for invalid input")
+	}
+}
+
+func decodeHeader(t *testing.T, s string) map[string]string {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Error decoding header: %s", err)
+	}
+	var header map[string]string
+	if err := json.Unmarshal(b, &header); err != nil {
+		t.Fatalf("Error unmarshaling header: %s", err)
+	}
+
+	return header
+}
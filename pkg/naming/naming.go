@@ -0,0 +1,187 @@
+package naming
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultDirectory is the directory include manifests are created in when
+// the policy does not set one.
+const DefaultDirectory = "includes"
+
+// Policy translates group or department names into include manifest paths
+// relative to the manifest directory.
+//
+// The name is looked up in Rename first. Names without an explicit rename
+// have their prefix replaced, whitespace normalised and case changed, in
+// that order. Path separators are always replaced and the result is
+// rejected if it could escape Directory.
+type Policy struct {
+	// Prefixes maps a prefix to its replacement. An empty replacement
+	// strips the prefix. The longest matching prefix is used.
+	Prefixes map[string]string `json:"prefixes"`
+	// Case is lower, upper or empty to leave the case unchanged.
+	Case string `json:"case"`
+	// Whitespace replaces runs of whitespace. When empty whitespace is
+	// only trimmed from the ends of the name.
+	Whitespace string `json:"whitespace"`
+	// Rename maps a name to the exact include name to use.
+	Rename map[string]string `json:"rename"`
+	// Directory the include manifests live in, e.g. includes/departments.
+	Directory string `json:"directory"`
+}
+
+// Validate checks the policy can only produce paths inside the manifest directory.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+
+	switch p.Case {
+	case "", "lower", "upper":
+	default:
+		return fmt.Errorf("unknown case %q, expected lower or upper", p.Case)
+	}
+
+	if _, err := p.directory(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(p.Whitespace, `/\`) {
+		return fmt.Errorf("whitespace replacement %q contains a path separator", p.Whitespace)
+	}
+
+	return nil
+}
+
+// Include returns the include manifest path for name, e.g. includes/eng.
+// A nil policy trims the name and places it in the default directory.
+func (p *Policy) Include(name string) (string, error) {
+	dir, err := p.directory()
+	if err != nil {
+		return "", err
+	}
+
+	n, err := p.name(name)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, n), nil
+}
+
+// Name returns the include manifest name for name without the directory,
+// for includes that live in a directory of their own such as the sites.
+func (p *Policy) Name(name string) (string, error) {
+	return p.name(name)
+}
+
+// Path joins the include to the manifest directory root, returning an error
+// if the result would be outside of root.
+func Path(root, include string) (string, error) {
+	full := filepath.Join(root, filepath.FromSlash(include))
+
+	rel, err := filepath.Rel(filepath.Clean(root), full)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("include %q is outside of %s", include, root)
+	}
+
+	return full, nil
+}
+
+func (p *Policy) name(name string) (string, error) {
+	original := name
+
+	if p != nil {
+		if renamed, ok := p.Rename[name]; ok {
+			name = renamed
+		} else {
+			name = p.transform(name)
+		}
+	} else {
+		name = strings.TrimSpace(name)
+	}
+
+	sep := "_"
+	if p != nil && p.Whitespace != "" {
+		sep = p.Whitespace
+	}
+	name = strings.NewReplacer("/", sep, `\`, sep).Replace(name)
+
+	if err := validName(name); err != nil {
+		return "", fmt.Errorf("invalid include name for %q: %w", original, err)
+	}
+
+	return name, nil
+}
+
+func (p *Policy) transform(name string) string {
+	// check the longest prefixes first so dept-eng- wins over dept-
+	prefixes := make([]string, 0, len(p.Prefixes))
+	for prefix := range p.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = p.Prefixes[prefix] + strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+
+	name = strings.TrimSpace(name)
+	if p.Whitespace != "" {
+		name = strings.Join(strings.Fields(name), p.Whitespace)
+	}
+
+	switch p.Case {
+	case "lower":
+		name = strings.ToLower(name)
+	case "upper":
+		name = strings.ToUpper(name)
+	}
+
+	return name
+}
+
+func (p *Policy) directory() (string, error) {
+	if p == nil || p.Directory == "" {
+		return DefaultDirectory, nil
+	}
+
+	dir := strings.Trim(path.Clean(filepath.ToSlash(p.Directory)), "/")
+	if dir == "." || dir == "" {
+		return "", fmt.Errorf("directory %q must be a subdirectory of the manifests", p.Directory)
+	}
+	if path.IsAbs(filepath.ToSlash(p.Directory)) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", fmt.Errorf("directory %q is outside of the manifests", p.Directory)
+	}
+
+	return dir, nil
+}
+
+func validName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("name is empty")
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("name %q starts with a dot", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("name %q contains a path separator", name)
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("name %q contains a control character", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package naming
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	policy := &Policy{
+		Prefixes:   map[string]string{"dept-": "", "dept-eng-": "eng_"},
+		Case:       "lower",
+		Whitespace: "_",
+		Rename:     map[string]string{"dept-People Ops": "people"},
+		Directory:  "includes/departments/",
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	tests := map[string]string{
+		"dept-Sales":             "includes/departments/sales",
+		"dept-eng-Platform":      "includes/departments/eng_platform",
+		"dept-Customer  Success": "includes/departments/customer_success",
+		"dept-People Ops":        "includes/departments/people",
+		"dept-R&D/Hardware":      "includes/departments/r&d_hardware",
+		"Finance":                "includes/departments/finance",
+	}
+	for name, expected := range tests {
+		got, err := policy.Include(name)
+		if err != nil {
+			t.Errorf("Include(%q) returned an error: %s", name, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Include(%q): expected %s, got %s", name, expected, got)
+		}
+	}
+
+	for _, name := range []string{"..", "dept-..", " ", "dept-", ".hidden", "bad\x00name"} {
+		if got, err := policy.Include(name); err == nil {
+			t.Errorf("Include(%q): expected an error, got %s", name, got)
+		}
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var policy *Policy
+
+	got, err := policy.Include("  TestDept ")
+	if err != nil {
+		t.Fatalf("Include returned an error: %s", err)
+	}
+	if got != "includes/TestDept" {
+		t.Errorf("Expected includes/TestDept, got %s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, p := range []*Policy{
+		{Directory: "../outside"},
+		{Directory: "/etc"},
+		{Directory: "."},
+		{Case: "title"},
+		{Whitespace: "/"},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", p)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	root := t.TempDir()
+
+	got, err := Path(root, "includes/eng")
+	if err != nil {
+		t.Fatalf("Path returned an error: %s", err)
+	}
+	if got != filepath.Join(root, "includes", "eng") {
+		t.Errorf("Unexpected path %s", got)
+	}
+
+	for _, include := range []string{"../eng", "includes/../../eng", "."} {
+		if _, err := Path(root, include); err == nil {
+			t.Errorf("Path(%q): expected an error", include)
+		}
+	}
+}
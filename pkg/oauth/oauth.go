@@ -0,0 +1,195 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"github.com/johnmikee/manifester/pkg/jwt"
+)
+
+const (
+	assertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// refreshSkew is how long before expiry a cached token is refreshed,
+	// at most half the lifetime of the token.
+	refreshSkew = 5 * time.Minute
+)
+
+// Config configures a TokenSource.
+type Config struct {
+	// Name is the service the tokens are for, used in errors, e.g. okta.
+	Name     string
+	ClientID string
+	KeyID    string
+	// PrivateKey is the PEM encoded key the client assertion is signed with.
+	PrivateKey []byte
+	Scopes     []string
+	TokenURL   string
+	// Audience of the client assertion, the token url when empty.
+	Audience string
+	// Algorithms limits the signing algorithms of the key, any supported
+	// algorithm is accepted when empty.
+	Algorithms []string
+	Client     *http.Client
+}
+
+// TokenSource fetches access tokens with the client credentials flow and a
+// private_key_jwt client assertion. Tokens are cached and refreshed shortly
+// before they expire.
+//   - https://www.rfc-editor.org/rfc/rfc7523#section-2.2
+type TokenSource struct {
+	// Now returns the current time, it can be replaced in tests.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	name     string
+	clientID string
+	keyID    string
+	key      crypto.Signer
+	keyErr   error
+	scopes   []string
+	tokenURL string
+	audience string
+	client   *http.Client
+
+	token   string
+	refresh time.Time // when the cached token is refreshed
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Scope            string `json:"scope"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewTokenSource parses the private key and returns the token source. A key
+// that cannot be used is reported by Err and by every call to Token.
+func NewTokenSource(c *Config) *TokenSource {
+	ts := &TokenSource{
+		Now:      time.Now,
+		name:     c.Name,
+		clientID: c.ClientID,
+		keyID:    c.KeyID,
+		scopes:   c.Scopes,
+		tokenURL: c.TokenURL,
+		audience: c.Audience,
+		client:   c.Client,
+	}
+	if ts.audience == "" {
+		ts.audience = ts.tokenURL
+	}
+	if ts.client == nil {
+		ts.client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	ts.key, ts.keyErr = jwt.ParsePrivateKey(c.PrivateKey)
+	if ts.keyErr == nil && len(c.Algorithms) > 0 {
+		if alg, _ := jwt.Algorithm(ts.key); !helpers.Contains(c.Algorithms, alg) {
+			ts.keyErr = fmt.Errorf("%s private key must sign with %s", c.Name, strings.Join(c.Algorithms, " or "))
+		}
+	}
+
+	return ts
+}
+
+// Err returns the error parsing the private key.
+func (t *TokenSource) Err() error {
+	return t.keyErr
+}
+
+// Token returns a cached access token or requests a new one when the
+// cached token is missing or about to expire.
+func (t *TokenSource) Token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && t.Now().Before(t.refresh) {
+		return t.token, nil
+	}
+
+	if t.keyErr != nil {
+		return "", fmt.Errorf("invalid %s private key: %w", t.name, t.keyErr)
+	}
+
+	assertion, err := t.assertion()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {t.clientID},
+		"scope":                 {strings.Join(t.scopes, " ")},
+		"client_assertion_type": {assertionType},
+		"client_assertion":      {assertion},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		return "", fmt.Errorf("%s token request failed: %s %s: %s", t.name, resp.Status, tr.Error, tr.ErrorDescription)
+	}
+
+	lifetime := time.Duration(tr.ExpiresIn) * time.Second
+	skew := refreshSkew
+	if lifetime/2 < skew {
+		skew = lifetime / 2
+	}
+	t.token = tr.AccessToken
+	t.refresh = t.Now().Add(lifetime - skew)
+
+	return t.token, nil
+}
+
+// Reset drops the cached token so the next request fetches a new one.
+func (t *TokenSource) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.token = ""
+}
+
+func (t *TokenSource) assertion() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := t.Now()
+	claims := map[string]interface{}{
+		"iss": t.clientID,
+		"sub": t.clientID,
+		"aud": t.audience,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+
+	return jwt.Sign(claims, t.key, t.keyID)
+}
@@ -0,0 +1,108 @@
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func key(t *testing.T, curve elliptic.Curve) []byte {
+	k, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	der, _ := x509.MarshalECPrivateKey(k)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestTokenSource(t *testing.T) {
+	var requests int
+	var audience string
+	expiresIn := 3600
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Error parsing form: %s", err)
+		}
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "client" || r.Form.Get("scope") != "a b" {
+			t.Errorf("Unexpected form %v", r.Form)
+		}
+		parts := strings.Split(r.Form.Get("client_assertion"), ".")
+		b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims map[string]interface{}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			t.Fatalf("Error decoding claims: %s", err)
+		}
+		audience, _ = claims["aud"].(string)
+
+		fmt.Fprintf(w, `{"access_token":"access-%d","expires_in":%d}`, requests, expiresIn)
+	}))
+	defer srv.Close()
+
+	ts := NewTokenSource(&Config{
+		Name:       "test",
+		ClientID:   "client",
+		PrivateKey: key(t, elliptic.P256()),
+		Scopes:     []string{"a", "b"},
+		TokenURL:   srv.URL,
+	})
+	now := time.Now()
+	ts.Now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if token, err := ts.Token(); err != nil || token != "access-1" {
+			t.Fatalf("Expected the cached token, got %s: %v", token, err)
+		}
+	}
+	if audience != srv.URL {
+		t.Errorf("Expected the token url as audience, got %s", audience)
+	}
+
+	now = now.Add(58 * time.Minute)
+	if token, _ := ts.Token(); token != "access-2" {
+		t.Errorf("Expected the token to be refreshed before expiry, got %s", token)
+	}
+
+	ts.Reset()
+	if token, _ := ts.Token(); token != "access-3" {
+		t.Errorf("Expected a new token after reset, got %s", token)
+	}
+
+	t.Run("short lifetime", func(t *testing.T) {
+		// tokens living less than twice the refresh skew are kept for
+		// half their lifetime
+		expiresIn = 120
+		ts.Reset()
+		for i := 0; i < 2; i++ {
+			if token, _ := ts.Token(); token != "access-4" {
+				t.Fatalf("Expected the short lived token to be cached, got %s", token)
+			}
+		}
+
+		now = now.Add(61 * time.Second)
+		if token, _ := ts.Token(); token != "access-5" {
+			t.Errorf("Expected the token to be refreshed after half its lifetime, got %s", token)
+		}
+	})
+
+	t.Run("algorithms", func(t *testing.T) {
+		ts := NewTokenSource(&Config{Name: "test", PrivateKey: key(t, elliptic.P384()), Algorithms: []string{"ES256"}})
+		if ts.Err() == nil {
+			t.Errorf("Expected an error for a P-384 key")
+		}
+		if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), "invalid test private key") {
+			t.Errorf("Expected a private key error, got %v", err)
+		}
+	})
+}
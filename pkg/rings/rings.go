@@ -0,0 +1,131 @@
+package rings
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Config splits the fleet into staged rollout rings. Devices are placed in a
+// ring by a stable hash of their serial number so the same devices are
+// always in the same ring, unless they are pinned to one.
+type Config struct {
+	// Salt is mixed into the hash. Changing it reshuffles every device.
+	Salt string `json:"salt"`
+	// Rings are ordered from the smallest to the largest. Percent is
+	// cumulative, a device is in the first ring its bucket falls under.
+	Rings []Ring `json:"rings"`
+}
+
+// Ring is a slice of the fleet that gets an include manifest or catalogs.
+type Ring struct {
+	Name string `json:"name"`
+	// Percent of the fleet, including the rings before it, e.g. canary 5,
+	// early 25 and broad 100.
+	Percent  float64  `json:"percent"`
+	Include  string   `json:"include"`  // include manifest, e.g. includes/ring_canary
+	Catalogs []string `json:"catalogs"` // catalogs in order
+	// Serials and Groups pin devices and the devices of group members to
+	// this ring regardless of their hash.
+	Serials []string `json:"serials"`
+	Groups  []string `json:"groups"`
+}
+
+// Validate checks every ring is named and the percentages increase up to 100.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Rings) == 0 {
+		return errors.New("no rings defined")
+	}
+
+	seen := make(map[string]bool)
+	prev := 0.0
+	for i, r := range c.Rings {
+		if r.Name == "" {
+			return fmt.Errorf("ring %d has no name", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("ring %s is defined more than once", r.Name)
+		}
+		seen[r.Name] = true
+
+		if r.Percent <= prev || r.Percent > 100 {
+			return fmt.Errorf("ring %s: percent must be greater than %v and at most 100", r.Name, prev)
+		}
+		prev = r.Percent
+
+		if r.Include == "" && len(r.Catalogs) == 0 {
+			return fmt.Errorf("ring %s has no include or catalogs", r.Name)
+		}
+	}
+
+	return nil
+}
+
+// GroupNames returns the groups used to pin devices to rings.
+func (c *Config) GroupNames() []string {
+	if c == nil {
+		return nil
+	}
+
+	var names []string
+	for _, r := range c.Rings {
+		names = append(names, r.Groups...)
+	}
+
+	return names
+}
+
+// Assign returns the ring for the device. Pins are checked first, in ring
+// order, then the hash of the serial. nil is returned when the device falls
+// outside every ring.
+func (c *Config) Assign(serial string, groups []string) *Ring {
+	if c == nil || serial == "" {
+		return nil
+	}
+
+	for i := range c.Rings {
+		if c.Rings[i].pinned(serial, groups) {
+			return &c.Rings[i]
+		}
+	}
+
+	b := Bucket(c.Salt, serial)
+	for i := range c.Rings {
+		if b < c.Rings[i].Percent {
+			return &c.Rings[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *Ring) pinned(serial string, groups []string) bool {
+	for _, s := range r.Serials {
+		if strings.EqualFold(s, serial) {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		for _, ug := range groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Bucket returns the position of the serial in the fleet, from 0 up to but
+// not including 100. Serials are compared case insensitively.
+func Bucket(salt, serial string) float64 {
+	sum := sha256.Sum256([]byte(salt + ":" + strings.ToUpper(serial)))
+	n := binary.BigEndian.Uint64(sum[:8])
+
+	return float64(n%10000) / 100
+}
@@ -0,0 +1,93 @@
+package rings
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testConfig() *Config {
+	return &Config{
+		Salt: "test",
+		Rings: []Ring{
+			{Name: "canary", Percent: 5, Include: "includes/ring_canary", Groups: []string{"it-canary"}},
+			{Name: "early", Percent: 25, Catalogs: []string{"testing", "production"}, Serials: []string{"C02PINNED"}},
+			{Name: "broad", Percent: 100, Include: "includes/ring_broad"},
+		},
+	}
+}
+
+func TestAssign(t *testing.T) {
+	c := testConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		first := c.Assign("C02ABC123", nil)
+		for i := 0; i < 10; i++ {
+			if got := c.Assign("c02abc123", nil); got.Name != first.Name {
+				t.Errorf("Expected ring %s, got %s", first.Name, got.Name)
+			}
+		}
+	})
+
+	t.Run("distribution", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			counts[c.Assign(fmt.Sprintf("SERIAL%05d", i), nil).Name]++
+		}
+		within := func(name string, expected int) {
+			if got := counts[name]; got < expected-200 || got > expected+200 {
+				t.Errorf("Expected about %d devices in %s, got %d", expected, name, got)
+			}
+		}
+		within("canary", 500)
+		within("early", 2000)
+		within("broad", 7500)
+	})
+
+	t.Run("pinned serial", func(t *testing.T) {
+		if got := c.Assign("c02pinned", nil); got.Name != "early" {
+			t.Errorf("Expected ring early, got %s", got.Name)
+		}
+	})
+
+	t.Run("pinned group", func(t *testing.T) {
+		if got := c.Assign("C02PINNED", []string{"it-canary"}); got.Name != "canary" {
+			t.Errorf("Expected the first pinned ring canary, got %s", got.Name)
+		}
+	})
+
+	t.Run("outside every ring", func(t *testing.T) {
+		partial := &Config{Rings: []Ring{{Name: "canary", Percent: 5, Include: "includes/ring_canary"}}}
+		outside := 0
+		for i := 0; i < 100; i++ {
+			if partial.Assign(fmt.Sprintf("SERIAL%03d", i), nil) == nil {
+				outside++
+			}
+		}
+		if outside == 0 {
+			t.Errorf("Expected devices outside the canary ring")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings []Ring
+	}{
+		{"empty", nil},
+		{"missing name", []Ring{{Percent: 5, Include: "a"}}},
+		{"decreasing", []Ring{{Name: "a", Percent: 25, Include: "a"}, {Name: "b", Percent: 5, Include: "b"}}},
+		{"over 100", []Ring{{Name: "a", Percent: 101, Include: "a"}}},
+		{"no action", []Ring{{Name: "a", Percent: 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&Config{Rings: tt.rings}).Validate(); err == nil {
+				t.Errorf("Expected an error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// CatalogPolicy assigns catalogs by group membership, e.g. to give the
+// members of a canary group testing before production.
+type CatalogPolicy struct {
+	// Groups are checked in order and the first group the user is a member
+	// of sets the catalogs.
+	Groups []CatalogGroup `json:"groups"`
+	// Default is used when the user is not a member of any of the groups.
+	// When empty the catalogs from the rules are kept.
+	Default []string `json:"default"`
+}
+
+// CatalogGroup sets the catalogs, in order, for the members of a group.
+type CatalogGroup struct {
+	Group    string   `json:"group"`
+	Catalogs []string `json:"catalogs"`
+}
+
+// DefaultCatalogSource is reported when the catalogs came from the policy default.
+const DefaultCatalogSource = "default"
+
+// Validate checks every group is named and has catalogs.
+func (p *CatalogPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+
+	for i, g := range p.Groups {
+		if g.Group == "" {
+			return fmt.Errorf("catalog group %d has no group", i)
+		}
+		if len(g.Catalogs) == 0 {
+			return fmt.Errorf("catalog group %s has no catalogs", g.Group)
+		}
+	}
+	if len(p.Groups) == 0 && len(p.Default) == 0 {
+		return errors.New("catalog policy has no groups or default")
+	}
+
+	return nil
+}
+
+// GroupNames returns the names of the groups in the policy.
+func (p *CatalogPolicy) GroupNames() []string {
+	if p == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(p.Groups))
+	for _, g := range p.Groups {
+		names = append(names, g.Group)
+	}
+
+	return names
+}
+
+// Assign returns the catalogs for a member of groups and the source of the
+// assignment, either group:<name> or default. ok is false when the policy
+// does not apply and the catalogs from the rules should be kept.
+func (p *CatalogPolicy) Assign(groups []string) (catalogs []string, source string, ok bool) {
+	if p == nil {
+		return nil, "", false
+	}
+
+	for _, g := range p.Groups {
+		if helpers.Contains(groups, g.Group) {
+			return append([]string{}, g.Catalogs...), "group:" + g.Group, true
+		}
+	}
+
+	if len(p.Default) > 0 {
+		return append([]string{}, p.Default...), DefaultCatalogSource, true
+	}
+
+	return nil, "", false
+}
@@ -0,0 +1,63 @@
+package rules
+
+import "fmt"
+
+// Class is a kind of shared device, e.g. lab or kiosk Macs, whose manifest
+// is not built around a user. A device is in the class when any of the
+// lists match, using the same patterns as Match.
+type Class struct {
+	Name string `json:"name"`
+	// Include replaces the user specific includes, default <name>_base
+	// named with the naming policy.
+	Include    string   `json:"include"`
+	Serials    []string `json:"serials,omitempty"`
+	Hostnames  []string `json:"hostnames,omitempty"`
+	Blueprints []string `json:"blueprints,omitempty"`
+	AssetTags  []string `json:"asset_tags,omitempty"`
+}
+
+// Classes are checked in order, a device is in the first class it matches.
+type Classes []Class
+
+// Validate checks every class is named once, has something to match and
+// valid patterns.
+func (c Classes) Validate() error {
+	seen := make(map[string]bool)
+	for i, class := range c {
+		if class.Name == "" {
+			return fmt.Errorf("device class %d has no name", i)
+		}
+		if seen[class.Name] {
+			return fmt.Errorf("duplicate device class %s", class.Name)
+		}
+		seen[class.Name] = true
+
+		if len(class.Serials)+len(class.Hostnames)+len(class.Blueprints)+len(class.AssetTags) == 0 {
+			return fmt.Errorf("device class %s matches nothing", class.Name)
+		}
+		if err := validatePatterns(class.Serials, class.Hostnames, class.Blueprints, class.AssetTags); err != nil {
+			return fmt.Errorf("device class %s: %w", class.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Match returns the class of the device or nil.
+func (c Classes) Match(d *Device) *Class {
+	for i, class := range c {
+		if matchSome(class.Serials, d.Serial) ||
+			matchSome(class.Hostnames, d.Hostname) ||
+			matchSome(class.Blueprints, d.Blueprint) ||
+			matchSome(class.AssetTags, d.AssetTag) {
+			return &c[i]
+		}
+	}
+
+	return nil
+}
+
+// matchSome is matchAny, except no patterns matches nothing.
+func matchSome(patterns []string, value string) bool {
+	return len(patterns) > 0 && value != "" && matchAny(patterns, value)
+}
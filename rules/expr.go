@@ -0,0 +1,34 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// compileWhen compiles and type checks a when expression against Facts. The
+// expression must return a bool, e.g.
+//
+//	user.department == "Eng" && device.model startsWith "MacBookPro" && !(user.title contains "Contractor")
+func compileWhen(when string) (*vm.Program, error) {
+	program, err := expr.Compile(when, expr.Env(Facts{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid when expression: %w", err)
+	}
+
+	return program, nil
+}
+
+// evalWhen runs the compiled expression against the facts. An expression
+// that fails at run time does not match.
+func evalWhen(program *vm.Program, f *Facts) (bool, error) {
+	out, err := expr.Run(program, *f)
+	if err != nil {
+		return false, err
+	}
+
+	ok, _ := out.(bool)
+
+	return ok, nil
+}
@@ -0,0 +1,250 @@
+package rules
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// Facts are the device and user attributes rules are matched against. The
+// expr tags name the fields in when expressions.
+type Facts struct {
+	Device Device `json:"device" expr:"device"`
+	User   User   `json:"user" expr:"user"`
+	// Now is the time rule windows are checked against, the current time
+	// when unset.
+	Now time.Time `json:"now,omitempty" expr:"now"`
+}
+
+// Device holds the attributes of the device from the MDM.
+type Device struct {
+	Serial          string    `json:"serial" expr:"serial"`
+	Hostname        string    `json:"hostname" expr:"hostname"`
+	Model           string    `json:"model" expr:"model"`
+	ModelIdentifier string    `json:"model_identifier" expr:"model_identifier"`
+	OSVersion       string    `json:"os_version" expr:"os_version"`
+	Platform        string    `json:"platform" expr:"platform"`
+	Architecture    string    `json:"architecture" expr:"architecture"`
+	LastCheckIn     time.Time `json:"last_check_in" expr:"last_check_in"`
+	Blueprint       string    `json:"blueprint" expr:"blueprint"`
+	AssetTag        string    `json:"asset_tag" expr:"asset_tag"`
+	// Class is the device class of a shared device, see Class.
+	Class string `json:"class" expr:"class"`
+}
+
+// User holds the attributes of the user assigned to the device.
+type User struct {
+	Username string `json:"username" expr:"username"`
+	Email    string `json:"email" expr:"email"`
+	// Department is the department on the user profile, Departments every
+	// department the user is a member of.
+	Department  string   `json:"department" expr:"department"`
+	Departments []string `json:"departments" expr:"departments"`
+	Title       string   `json:"title" expr:"title"`
+	Location    string   `json:"location" expr:"location"`
+	// Managers are the emails of the management chain, the direct manager first.
+	Managers []string `json:"managers" expr:"managers"`
+}
+
+// Match lists the patterns a device must match for a rule to apply. Every
+// field that is set must match. Patterns are case insensitive globs, e.g.
+// MacBookPro*, and a pattern prefixed with ! excludes matching values. A
+// field matches when any pattern matches and no exclusion does.
+//
+// OSVersion patterns may also be a comparison such as >=14 or <13.5.
+type Match struct {
+	Department []string `json:"department,omitempty"`
+	Title      []string `json:"title,omitempty"`
+	Location   []string `json:"location,omitempty"`
+	Model      []string `json:"model,omitempty"`
+	// ModelIdentifier matches e.g. MacBookPro18,3 or Mac14,*.
+	ModelIdentifier []string `json:"model_identifier,omitempty"`
+	// Architecture is arm64 for Apple silicon or x86_64 for Intel.
+	Architecture []string `json:"architecture,omitempty"`
+	OSVersion    []string `json:"os_version,omitempty"`
+	Blueprint    []string `json:"blueprint,omitempty"`
+	Platform     []string `json:"platform,omitempty"`
+	Serials      []string `json:"serials,omitempty"`
+	Class        []string `json:"class,omitempty"`
+	// ReportsTo matches anyone with the manager anywhere in their
+	// management chain, by email or username.
+	ReportsTo []string `json:"reports_to,omitempty"`
+	// HasUser matches devices with (true) or without (false) an assigned user.
+	HasUser *bool `json:"has_user,omitempty"`
+}
+
+func (m *Match) matches(f *Facts) bool {
+	if m.HasUser != nil && *m.HasUser != (f.User.Username != "") {
+		return false
+	}
+
+	return matchAny(m.Department, f.User.departments()...) &&
+		matchAny(m.Title, f.User.Title) &&
+		matchAny(m.Location, f.User.Location) &&
+		matchAny(m.Model, f.Device.Model) &&
+		matchAny(m.ModelIdentifier, f.Device.ModelIdentifier) &&
+		matchAny(m.Architecture, f.Device.Architecture) &&
+		matchAny(m.OSVersion, f.Device.OSVersion) &&
+		matchAny(m.Blueprint, f.Device.Blueprint) &&
+		matchAny(m.Platform, f.Device.Platform) &&
+		matchAny(m.Serials, f.Device.Serial) &&
+		matchAny(m.Class, f.Device.Class) &&
+		matchAny(m.ReportsTo, f.User.managers()...)
+}
+
+// departments returns the profile department and group departments.
+func (u *User) departments() []string {
+	if u.Department == "" || helpers.Contains(u.Departments, u.Department) {
+		return u.Departments
+	}
+
+	return append([]string{u.Department}, u.Departments...)
+}
+
+// managers returns the emails and usernames of the management chain.
+func (u *User) managers() []string {
+	res := make([]string, 0, 2*len(u.Managers))
+	for _, m := range u.Managers {
+		res = append(res, m, strings.Split(m, "@")[0])
+	}
+
+	return res
+}
+
+func (m *Match) validate() error {
+	return validatePatterns(
+		m.Department, m.Title, m.Location, m.Model, m.ModelIdentifier, m.Architecture,
+		m.OSVersion, m.Blueprint, m.Platform, m.Serials, m.Class, m.ReportsTo,
+	)
+}
+
+func validatePatterns(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, p := range patterns {
+			p = strings.TrimPrefix(p, "!")
+			if op, v := versionConstraint(p); op != "" {
+				if _, err := parseVersion(v); err != nil {
+					return fmt.Errorf("invalid version in %q: %w", p, err)
+				}
+				continue
+			}
+			if _, err := path.Match(strings.ToLower(p), ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchAny reports whether any of the values match the patterns. An empty
+// list of patterns matches everything.
+func matchAny(patterns []string, values ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	included, hasInclude := false, false
+	for _, p := range patterns {
+		exclude := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if !exclude {
+			hasInclude = true
+		}
+
+		for _, v := range values {
+			if !matchPattern(p, v) {
+				continue
+			}
+			if exclude {
+				return false
+			}
+			included = true
+		}
+	}
+
+	return included || !hasInclude
+}
+
+func matchPattern(pattern, value string) bool {
+	if op, v := versionConstraint(pattern); op != "" {
+		return compareVersion(value, op, v)
+	}
+
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+
+	return ok
+}
+
+// versionConstraint splits a pattern like >=14.1 into its operator and version.
+func versionConstraint(p string) (string, string) {
+	for _, op := range []string{">=", "<=", "==", ">", "<", "="} {
+		if strings.HasPrefix(p, op) {
+			return op, strings.TrimSpace(strings.TrimPrefix(p, op))
+		}
+	}
+
+	return "", ""
+}
+
+func compareVersion(value, op, constraint string) bool {
+	a, err := parseVersion(value)
+	if err != nil {
+		return false
+	}
+	b, _ := parseVersion(constraint)
+
+	c := 0
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				c = -1
+			} else {
+				c = 1
+			}
+			break
+		}
+	}
+
+	switch op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	default:
+		return c == 0
+	}
+}
+
+func parseVersion(v string) ([]int, error) {
+	if v == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+
+	parts := strings.Split(v, ".")
+	res := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = n
+	}
+
+	return res, nil
+}
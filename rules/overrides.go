@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johnmikee/manifester/pkg/helpers"
+)
+
+// Overrides are one-off changes for a device or user merged on top of the
+// generated manifest.
+type Overrides struct {
+	Serials map[string]OverrideList `json:"serials"`
+	Users   map[string]OverrideList `json:"users"`
+}
+
+// Override adds to and removes from a manifest. Catalogs replace the
+// generated catalogs instead of being merged.
+type Override struct {
+	// Name identifies the override in the manifest metadata and run
+	// summary, e.g. a ticket number.
+	Name string `json:"name,omitempty"`
+	Actions
+	Remove Actions `json:"remove"`
+	Window
+}
+
+// OverrideList is the overrides for a serial or user. A single override may
+// be given instead of a list.
+type OverrideList []Override
+
+func (l *OverrideList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var o Override
+		if err := json.Unmarshal(data, &o); err != nil {
+			return err
+		}
+		*l = OverrideList{o}
+		return nil
+	}
+
+	var list []Override
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+
+	return nil
+}
+
+// LoadOverrides reads the overrides from a JSON or YAML file, or from every
+// .json, .yaml and .yml file in a directory. Overrides for the same serial
+// or user in different files are all applied, in file name order.
+func LoadOverrides(path string) (*Overrides, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".json", ".yaml", ".yml":
+				if !e.IsDir() {
+					files = append(files, filepath.Join(path, e.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+	}
+
+	o := &Overrides{
+		Serials: make(map[string]OverrideList),
+		Users:   make(map[string]OverrideList),
+	}
+	for _, file := range files {
+		var fo Overrides
+		if err := decodeFile(file, &fo); err != nil {
+			return nil, fmt.Errorf("failed to decode overrides %s: %w", file, err)
+		}
+		if err := merge(o.Serials, fo.Serials, strings.ToUpper); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := merge(o.Users, fo.Users, strings.ToLower); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return o, nil
+}
+
+func merge(dst, src map[string]OverrideList, key func(string) string) error {
+	for k, list := range src {
+		for _, v := range list {
+			if err := v.Window.validate(); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		k = key(k)
+		dst[k] = append(dst[k], list...)
+	}
+
+	return nil
+}
+
+// Prune removes the overrides that expired before now and returns their names.
+func (o *Overrides) Prune(now time.Time) []string {
+	if o == nil {
+		return nil
+	}
+
+	var expired []string
+	prune := func(m map[string]OverrideList, kind string) {
+		for k, list := range m {
+			active := list[:0]
+			for _, v := range list {
+				if v.Expired(now) {
+					expired = append(expired, overrideName(kind, k, &v))
+					continue
+				}
+				active = append(active, v)
+			}
+			m[k] = active
+		}
+	}
+	prune(o.Users, "user")
+	prune(o.Serials, "serial")
+	sort.Strings(expired)
+
+	return expired
+}
+
+// Includes returns the included manifests the overrides add.
+func (o *Overrides) Includes() []string {
+	if o == nil {
+		return nil
+	}
+
+	var res []string
+	for _, m := range []map[string]OverrideList{o.Users, o.Serials} {
+		for _, list := range m {
+			for _, v := range list {
+				res = append(res, v.IncludedManifests...)
+			}
+		}
+	}
+
+	return res
+}
+
+// For returns the names and overrides that are active for the device at now,
+// the user overrides first so the more specific serial overrides are applied
+// last.
+func (o *Overrides) For(serial, username string, now time.Time) ([]string, []Override) {
+	if o == nil {
+		return nil, nil
+	}
+
+	var names []string
+	var res []Override
+	add := func(list OverrideList, kind, key string) {
+		for i := range list {
+			if list[i].Active(now) {
+				names = append(names, overrideName(kind, key, &list[i]))
+				res = append(res, list[i])
+			}
+		}
+	}
+	if username != "" {
+		add(o.Users[strings.ToLower(username)], "user", strings.ToLower(username))
+	}
+	if serial != "" {
+		add(o.Serials[strings.ToUpper(serial)], "serial", strings.ToUpper(serial))
+	}
+
+	return names, res
+}
+
+// overrideName returns the name of the override, e.g. user:jane or
+// user:jane:INC-1234 when it is named.
+func overrideName(kind, key string, o *Override) string {
+	name := kind + ":" + key
+	if o.Name != "" {
+		name += ":" + o.Name
+	}
+
+	return name
+}
+
+// Apply merges the override into a and reports whether it replaced the catalogs.
+func (o *Override) Apply(a *Actions) bool {
+	a.IncludedManifests = remove(appendUnique(a.IncludedManifests, o.IncludedManifests...), o.Remove.IncludedManifests)
+	a.ManagedInstalls = remove(appendUnique(a.ManagedInstalls, o.ManagedInstalls...), o.Remove.ManagedInstalls)
+	a.OptionalInstalls = remove(appendUnique(a.OptionalInstalls, o.OptionalInstalls...), o.Remove.OptionalInstalls)
+	a.OptionalInstalls = remove(a.OptionalInstalls, a.ManagedInstalls)
+
+	replaced := len(o.Catalogs) > 0
+	if replaced {
+		a.Catalogs = append([]string{}, o.Catalogs...)
+	}
+	a.Catalogs = remove(a.Catalogs, o.Remove.Catalogs)
+
+	return replaced
+}
+
+func remove(s []string, items []string) []string {
+	if len(items) == 0 {
+		return s
+	}
+
+	res := []string{}
+	for _, v := range s {
+		if !helpers.Contains(items, v) {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
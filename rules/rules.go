@@ -0,0 +1,344 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+	"github.com/johnmikee/manifester/pkg/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// Ruleset is a list of targeting rules evaluated against each device.
+type Ruleset struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule adds manifests, catalogs and items to the devices it matches.
+type Rule struct {
+	Name string `json:"name"`
+	// Priority orders evaluation, higher priorities are evaluated first.
+	// Rules with the same priority are evaluated in file order.
+	Priority int   `json:"priority"`
+	Match    Match `json:"match"`
+	// When is an expression that must also be true for the rule to match,
+	// see compileWhen.
+	When string `json:"when,omitempty"`
+	// Stop ends evaluation after this rule matches.
+	Stop bool `json:"stop"`
+	Actions
+	Window
+
+	when *vm.Program
+}
+
+// Actions are what a rule adds to the manifest of a matched device.
+type Actions struct {
+	IncludedManifests []string `json:"included_manifests,omitempty"`
+	Catalogs          []string `json:"catalogs,omitempty"`
+	ManagedInstalls   []string `json:"managed_installs,omitempty"`
+	OptionalInstalls  []string `json:"optional_installs,omitempty"`
+}
+
+// Result is the outcome of evaluating a ruleset for a device.
+type Result struct {
+	Actions
+	// CatalogsRule is the name of the rule the catalogs were taken from.
+	CatalogsRule string `json:"catalogs_rule,omitempty"`
+	// Matched lists the rules that matched in evaluation order.
+	Matched []string `json:"matched"`
+	// Errors lists the rules whose when expression failed to run.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Default returns the built in rules used when no rules file is configured.
+//
+// Every device gets the production catalog and the base includes,
+// devices with an assigned user also get includes/security.
+func Default() *Ruleset {
+	assigned := true
+
+	return &Ruleset{
+		Rules: []Rule{
+			{
+				Name: "base",
+				Actions: Actions{
+					Catalogs: []string{"production"},
+					IncludedManifests: []string{
+						"includes/apple_apps",
+						"includes/common_base",
+						"includes/optional_apps",
+					},
+				},
+			},
+			{
+				Name:  "assigned-user",
+				Match: Match{HasUser: &assigned},
+				Actions: Actions{
+					IncludedManifests: []string{"includes/security"},
+				},
+			},
+		},
+	}
+}
+
+// Load reads and validates the rules file at path. Files ending in .yaml or
+// .yml are decoded as YAML, anything else as JSON.
+func Load(path string) (*Ruleset, error) {
+	var rs Ruleset
+	if err := decodeFile(path, &rs); err != nil {
+		return nil, fmt.Errorf("failed to decode rules: %w", err)
+	}
+
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &rs, nil
+}
+
+// Validate checks every rule is named uniquely, its match patterns are valid
+// and compiles its when expression.
+func (rs *Ruleset) Validate() error {
+	seen := make(map[string]bool)
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("rule %s is defined more than once", r.Name)
+		}
+		seen[r.Name] = true
+
+		if err := r.Match.validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+
+		if err := r.Window.validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+
+		if r.When != "" {
+			program, err := compileWhen(r.When)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			r.when = program
+		}
+	}
+
+	return nil
+}
+
+// Prune removes the rules that expired before now and returns their names.
+func (rs *Ruleset) Prune(now time.Time) []string {
+	if rs == nil {
+		return nil
+	}
+
+	var expired []string
+	rules := rs.Rules[:0]
+	for _, r := range rs.Rules {
+		if r.Expired(now) {
+			expired = append(expired, r.Name)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	rs.Rules = rules
+
+	return expired
+}
+
+// Includes returns the included manifests the rules add.
+func (rs *Ruleset) Includes() []string {
+	var res []string
+	for _, r := range rs.Rules {
+		res = append(res, r.IncludedManifests...)
+	}
+
+	return res
+}
+
+// Evaluate applies every matching rule that is active at the time in the
+// facts to the facts.
+//
+// Conflicts are resolved as follows:
+//   - included manifests and items are merged in priority order without duplicates.
+//   - catalogs are taken from the highest priority matching rule that sets them,
+//     catalogs from lower priority rules are ignored.
+//   - an item that is both a managed and optional install is only kept as managed.
+func (rs *Ruleset) Evaluate(f *Facts) Result {
+	res := Result{Matched: []string{}}
+	if rs == nil {
+		return res
+	}
+
+	if f.Now.IsZero() {
+		withNow := *f
+		withNow.Now = time.Now()
+		f = &withNow
+	}
+
+	now := f.Now
+	for _, r := range rs.ordered() {
+		if !r.Active(now) || !r.Match.matches(f) {
+			continue
+		}
+		if r.When != "" {
+			ok, err := r.evalWhen(f)
+			if err != nil {
+				res.Errors = append(res.Errors, fmt.Sprintf("%s: %s", r.Name, err))
+			}
+			if !ok {
+				continue
+			}
+		}
+		res.Matched = append(res.Matched, r.Name)
+
+		res.IncludedManifests = appendUnique(res.IncludedManifests, r.IncludedManifests...)
+		res.ManagedInstalls = appendUnique(res.ManagedInstalls, r.ManagedInstalls...)
+		res.OptionalInstalls = appendUnique(res.OptionalInstalls, r.OptionalInstalls...)
+		if res.Catalogs == nil && len(r.Catalogs) > 0 {
+			res.Catalogs = append([]string{}, r.Catalogs...)
+			res.CatalogsRule = r.Name
+		}
+
+		if r.Stop {
+			break
+		}
+	}
+
+	optional := []string{}
+	for _, item := range res.OptionalInstalls {
+		if !helpers.Contains(res.ManagedInstalls, item) {
+			optional = append(optional, item)
+		}
+	}
+	if len(optional) > 0 {
+		res.OptionalInstalls = optional
+	} else {
+		res.OptionalInstalls = nil
+	}
+
+	return res
+}
+
+// evalWhen runs the when expression, compiling it first when the rule was
+// not validated.
+func (r *Rule) evalWhen(f *Facts) (bool, error) {
+	if r.when == nil {
+		program, err := compileWhen(r.When)
+		if err != nil {
+			return false, err
+		}
+		r.when = program
+	}
+
+	return evalWhen(r.when, f)
+}
+
+// ordered returns the rules sorted by descending priority, keeping file order for ties.
+func (rs *Ruleset) ordered() []Rule {
+	rules := append([]Rule{}, rs.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+
+	return rules
+}
+
+// Fixture is a device used to test a ruleset.
+type Fixture struct {
+	Name string `json:"name"`
+	Facts
+	// Expect is compared to the result when set. Fields left empty are not compared.
+	Expect *Actions `json:"expect,omitempty"`
+}
+
+// LoadFixtures reads the fixtures file at path.
+func LoadFixtures(path string) ([]Fixture, error) {
+	var fixtures []Fixture
+	if err := decodeFile(path, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to decode fixtures: %w", err)
+	}
+	if len(fixtures) == 0 {
+		return nil, errors.New("no fixtures found")
+	}
+
+	return fixtures, nil
+}
+
+// Test evaluates the fixture and returns the result and a description of
+// every difference from the expected result.
+func (rs *Ruleset) Test(f *Fixture) (Result, []string) {
+	res := rs.Evaluate(&f.Facts)
+	if f.Expect == nil {
+		return res, nil
+	}
+
+	var diffs []string
+	compare := func(key string, expected, got []string) {
+		if expected == nil || equal(expected, got) {
+			return
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: expected %v, got %v", key, expected, got))
+	}
+	compare("included_manifests", f.Expect.IncludedManifests, res.IncludedManifests)
+	compare("catalogs", f.Expect.Catalogs, res.Catalogs)
+	compare("managed_installs", f.Expect.ManagedInstalls, res.ManagedInstalls)
+	compare("optional_installs", f.Expect.OptionalInstalls, res.OptionalInstalls)
+
+	return res, diffs
+}
+
+// decodeFile decodes a JSON or YAML file into v. YAML is converted to JSON
+// first so the json tags are used for both formats.
+func decodeFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		data, err = json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func appendUnique(s []string, items ...string) []string {
+	for _, item := range items {
+		if !helpers.Contains(s, item) {
+			s = append(s, item)
+		}
+	}
+
+	return s
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,416 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	t.Run("device with user", func(t *testing.T) {
+		res := Default().Evaluate(&Facts{User: User{Username: "jane"}})
+		expected := []string{"includes/apple_apps", "includes/common_base", "includes/optional_apps", "includes/security"}
+		if !equal(res.IncludedManifests, expected) {
+			t.Errorf("Expected %v, got %v", expected, res.IncludedManifests)
+		}
+		if !equal(res.Catalogs, []string{"production"}) {
+			t.Errorf("Expected production catalog, got %v", res.Catalogs)
+		}
+	})
+
+	t.Run("device without user", func(t *testing.T) {
+		res := Default().Evaluate(&Facts{})
+		expected := []string{"includes/apple_apps", "includes/common_base", "includes/optional_apps"}
+		if !equal(res.IncludedManifests, expected) {
+			t.Errorf("Expected %v, got %v", expected, res.IncludedManifests)
+		}
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	rs := &Ruleset{
+		Rules: []Rule{
+			{
+				Name:    "base",
+				Actions: Actions{Catalogs: []string{"production"}, OptionalInstalls: []string{"Slack", "Zoom"}},
+			},
+			{
+				Name:     "engineering",
+				Priority: 10,
+				Match:    Match{Department: []string{"eng*"}, Model: []string{"MacBook Pro*", "!*Intel*"}},
+				Actions: Actions{
+					Catalogs:          []string{"testing", "production"},
+					IncludedManifests: []string{"includes/dev_tools"},
+					ManagedInstalls:   []string{"Zoom"},
+				},
+			},
+			{
+				Name:     "legacy",
+				Priority: 20,
+				Match:    Match{OSVersion: []string{"<13"}},
+				Stop:     true,
+				Actions:  Actions{IncludedManifests: []string{"includes/legacy"}},
+			},
+		},
+	}
+	if err := rs.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	t.Run("priority and conflicts", func(t *testing.T) {
+		res := rs.Evaluate(&Facts{
+			Device: Device{Model: "MacBook Pro (14-inch, 2023)", OSVersion: "14.1"},
+			User:   User{Departments: []string{"Engineering"}},
+		})
+		if !equal(res.Matched, []string{"engineering", "base"}) {
+			t.Errorf("Expected engineering then base to match, got %v", res.Matched)
+		}
+		if !equal(res.Catalogs, []string{"testing", "production"}) || res.CatalogsRule != "engineering" {
+			t.Errorf("Expected catalogs from engineering, got %v from %s", res.Catalogs, res.CatalogsRule)
+		}
+		if !equal(res.OptionalInstalls, []string{"Slack"}) {
+			t.Errorf("Expected Zoom to only be a managed install, got %v", res.OptionalInstalls)
+		}
+	})
+
+	t.Run("exclusion", func(t *testing.T) {
+		res := rs.Evaluate(&Facts{
+			Device: Device{Model: "MacBook Pro (Intel, 2019)", OSVersion: "13.6"},
+			User:   User{Departments: []string{"Engineering"}},
+		})
+		if !equal(res.Matched, []string{"base"}) {
+			t.Errorf("Expected only base to match, got %v", res.Matched)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		res := rs.Evaluate(&Facts{Device: Device{OSVersion: "12.7.1"}})
+		if !equal(res.Matched, []string{"legacy"}) {
+			t.Errorf("Expected evaluation to stop at legacy, got %v", res.Matched)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rs   Ruleset
+	}{
+		{"missing name", Ruleset{Rules: []Rule{{}}}},
+		{"duplicate name", Ruleset{Rules: []Rule{{Name: "a"}, {Name: "a"}}}},
+		{"bad pattern", Ruleset{Rules: []Rule{{Name: "a", Match: Match{Model: []string{"[Mac"}}}}}},
+		{"bad version", Ruleset{Rules: []Rule{{Name: "a", Match: Match{OSVersion: []string{">=fourteen"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rs.Validate(); err == nil {
+				t.Errorf("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "rules.yaml")
+	fixturesFile := filepath.Join(dir, "fixtures.yml")
+
+	err := os.WriteFile(rulesFile, []byte(`
+rules:
+  - name: sales
+    match:
+      department: [sales]
+      platform: [Mac]
+    included_manifests: [includes/sales_apps]
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(fixturesFile, []byte(`
+- name: sales mac
+  device: {serial: C02ABC123, platform: Mac}
+  user: {username: jane, departments: [Sales]}
+  expect:
+    included_manifests: [includes/sales_apps]
+- name: sales iphone
+  device: {platform: iPhone}
+  user: {departments: [Sales]}
+  expect:
+    included_manifests: [includes/sales_apps]
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := Load(rulesFile)
+	if err != nil {
+		t.Fatalf("Load returned an error: %s", err)
+	}
+	fixtures, err := LoadFixtures(fixturesFile)
+	if err != nil {
+		t.Fatalf("LoadFixtures returned an error: %s", err)
+	}
+
+	if _, diffs := rs.Test(&fixtures[0]); len(diffs) != 0 {
+		t.Errorf("Expected fixture to pass, got %v", diffs)
+	}
+	if _, diffs := rs.Test(&fixtures[1]); len(diffs) != 1 {
+		t.Errorf("Expected fixture to fail with 1 difference, got %v", diffs)
+	}
+}
+
+func TestWhen(t *testing.T) {
+	rs := &Ruleset{
+		Rules: []Rule{
+			{
+				Name:    "eng-laptops",
+				When:    `user.department == "Eng" && device.model startsWith "MacBookPro" && !(user.title contains "Contractor")`,
+				Actions: Actions{IncludedManifests: []string{"includes/eng_laptops"}},
+			},
+		},
+	}
+	if err := rs.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		facts    Facts
+		expected bool
+	}{
+		{"match", Facts{Device: Device{Model: "MacBookPro18,3"}, User: User{Department: "Eng", Title: "Engineer"}}, true},
+		{"contractor", Facts{Device: Device{Model: "MacBookPro18,3"}, User: User{Department: "Eng", Title: "Contractor"}}, false},
+		{"model", Facts{Device: Device{Model: "MacBookAir10,1"}, User: User{Department: "Eng"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := rs.Evaluate(&tt.facts)
+			if got := len(res.Matched) == 1; got != tt.expected {
+				t.Errorf("Expected match %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("type errors fail at load", func(t *testing.T) {
+		for _, when := range []string{
+			`user.departmnt == "Eng"`,
+			`device.model`,
+			`user.departments > 1`,
+		} {
+			bad := &Ruleset{Rules: []Rule{{Name: "bad", When: when}}}
+			if err := bad.Validate(); err == nil {
+				t.Errorf("Expected %q to fail validation", when)
+			}
+		}
+	})
+}
+
+func TestCatalogPolicy(t *testing.T) {
+	p := &CatalogPolicy{
+		Groups: []CatalogGroup{
+			{Group: "it-canary", Catalogs: []string{"testing", "production"}},
+			{Group: "beta-testers", Catalogs: []string{"beta", "production"}},
+		},
+		Default: []string{"production"},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		groups   []string
+		catalogs []string
+		source   string
+	}{
+		{"canary", []string{"dept-eng", "it-canary"}, []string{"testing", "production"}, "group:it-canary"},
+		{"first group wins", []string{"beta-testers", "it-canary"}, []string{"testing", "production"}, "group:it-canary"},
+		{"beta", []string{"beta-testers"}, []string{"beta", "production"}, "group:beta-testers"},
+		{"default", nil, []string{"production"}, DefaultCatalogSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalogs, source, ok := p.Assign(tt.groups)
+			if !ok || !equal(catalogs, tt.catalogs) || source != tt.source {
+				t.Errorf("Expected %v from %s, got %v from %s", tt.catalogs, tt.source, catalogs, source)
+			}
+		})
+	}
+
+	t.Run("no default keeps rules", func(t *testing.T) {
+		p := &CatalogPolicy{Groups: p.Groups}
+		if _, _, ok := p.Assign([]string{"dept-eng"}); ok {
+			t.Errorf("Expected the policy not to apply")
+		}
+	})
+}
+
+func TestClasses(t *testing.T) {
+	classes := Classes{
+		{Name: "lab", Serials: []string{"C02LAB001"}, Hostnames: []string{"lab-*"}},
+		{Name: "kiosk", Include: "includes/kiosk", Blueprints: []string{"Kiosk*"}, AssetTags: []string{"KSK-*"}},
+	}
+	if err := classes.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		device   Device
+		expected string
+	}{
+		{"serial", Device{Serial: "C02LAB001"}, "lab"},
+		{"hostname", Device{Hostname: "LAB-14"}, "lab"},
+		{"blueprint", Device{Blueprint: "Kiosk Macs"}, "kiosk"},
+		{"asset tag", Device{AssetTag: "KSK-0042"}, "kiosk"},
+		{"none", Device{Serial: "C02ABC123", Hostname: "janes-mbp"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if class := classes.Match(&tt.device); class != nil {
+				got = class.Name
+			}
+			if got != tt.expected {
+				t.Errorf("Expected class %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, c := range []Classes{
+			{{Serials: []string{"C02LAB001"}}},
+			{{Name: "lab"}},
+			{{Name: "lab", Serials: []string{"a"}}, {Name: "lab", Serials: []string{"b"}}},
+			{{Name: "lab", Hostnames: []string{"lab-["}}},
+		} {
+			if err := c.Validate(); err == nil {
+				t.Errorf("Expected %v to be invalid", c)
+			}
+		}
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("devices.yaml", `
+serials:
+  c02abc123:
+    included_manifests: [includes/adobe_cc]
+    remove:
+      included_manifests: [includes/security]
+`)
+	write("users.json", `{"users": {"Jane": {"catalogs": ["testing", "production"], "managed_installs": ["Slack"]}}}`)
+	write("README.md", "not an override")
+
+	o, err := LoadOverrides(dir)
+	if err != nil {
+		t.Fatalf("LoadOverrides returned an error: %s", err)
+	}
+
+	names, overrides := o.For("C02ABC123", "jane", time.Now())
+	if !equal(names, []string{"user:jane", "serial:C02ABC123"}) {
+		t.Fatalf("Expected the user then serial override, got %v", names)
+	}
+
+	a := Actions{
+		IncludedManifests: []string{"includes/common_base", "includes/security"},
+		Catalogs:          []string{"production"},
+		OptionalInstalls:  []string{"Slack", "Zoom"},
+	}
+	replaced := false
+	for i := range overrides {
+		replaced = overrides[i].Apply(&a) || replaced
+	}
+
+	if !replaced || !equal(a.Catalogs, []string{"testing", "production"}) {
+		t.Errorf("Expected catalogs to be replaced, got %v", a.Catalogs)
+	}
+	if !equal(a.IncludedManifests, []string{"includes/common_base", "includes/adobe_cc"}) {
+		t.Errorf("Expected security to be removed and adobe_cc added, got %v", a.IncludedManifests)
+	}
+	if !equal(a.OptionalInstalls, []string{"Zoom"}) {
+		t.Errorf("Expected Slack to only be a managed install, got %v", a.OptionalInstalls)
+	}
+
+	t.Run("same serial in another file", func(t *testing.T) {
+		write("ticket.yaml", `
+serials:
+  C02ABC123:
+    - name: INC-1234
+      included_manifests: [includes/xcode]
+      expires: 2024-02-01T00:00:00Z
+    - name: INC-1300
+      included_manifests: [includes/final_cut]
+      not_before: 2024-03-01T00:00:00Z
+`)
+		o, err := LoadOverrides(dir)
+		if err != nil {
+			t.Fatalf("LoadOverrides returned an error: %s", err)
+		}
+
+		names, _ := o.For("C02ABC123", "", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+		if !equal(names, []string{"serial:C02ABC123", "serial:C02ABC123:INC-1234"}) {
+			t.Errorf("Expected both active overrides, got %v", names)
+		}
+
+		expired := o.Prune(time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC))
+		if !equal(expired, []string{"serial:C02ABC123:INC-1234"}) {
+			t.Errorf("Expected INC-1234 to expire, got %v", expired)
+		}
+		names, _ = o.For("C02ABC123", "", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+		if !equal(names, []string{"serial:C02ABC123", "serial:C02ABC123:INC-1300"}) {
+			t.Errorf("Expected INC-1300 to start, got %v", names)
+		}
+	})
+}
+
+func TestWindow(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC) }
+	start, end := day(10), day(20)
+
+	rs := &Ruleset{
+		Rules: []Rule{
+			{Name: "adobe", Window: Window{NotBefore: &start, Expires: &end}, Actions: Actions{IncludedManifests: []string{"includes/adobe_cc"}}},
+			{Name: "base", Actions: Actions{Catalogs: []string{"production"}}},
+		},
+	}
+	if err := rs.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %s", err)
+	}
+
+	tests := []struct {
+		now      time.Time
+		expected []string
+	}{
+		{day(5), []string{"base"}},
+		{day(15), []string{"adobe", "base"}},
+		{day(20), []string{"base"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.now.Format("2006-01-02"), func(t *testing.T) {
+			if res := rs.Evaluate(&Facts{Now: tt.now}); !equal(res.Matched, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, res.Matched)
+			}
+		})
+	}
+
+	t.Run("prune", func(t *testing.T) {
+		if expired := rs.Prune(day(25)); !equal(expired, []string{"adobe"}) || len(rs.Rules) != 1 {
+			t.Errorf("Expected adobe to be pruned, got %v", expired)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		bad := &Ruleset{Rules: []Rule{{Name: "bad", Window: Window{NotBefore: &end, Expires: &start}}}}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Expected an error for not_before after expires")
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window limits when a rule or override applies. Timestamps are RFC 3339,
+// e.g. 2024-06-01T00:00:00Z, and either may be left unset.
+type Window struct {
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+}
+
+// Active reports whether now falls inside the window.
+func (w *Window) Active(now time.Time) bool {
+	if w.NotBefore != nil && now.Before(*w.NotBefore) {
+		return false
+	}
+
+	return !w.Expired(now)
+}
+
+// Expired reports whether the window ended before now.
+func (w *Window) Expired(now time.Time) bool {
+	return w.Expires != nil && !now.Before(*w.Expires)
+}
+
+func (w *Window) validate() error {
+	if w.NotBefore != nil && w.Expires != nil && !w.NotBefore.Before(*w.Expires) {
+		return fmt.Errorf("not_before %s is not before expires %s", w.NotBefore.Format(time.RFC3339), w.Expires.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	memberPathRegex = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]+)"\s*\]$`)
+	// filterPathRegex matches a path with a value filter, e.g.
+	// emails[type eq "work"].value, capturing the attribute, the filter
+	// attribute and value and the optional sub-attribute.
+	filterPathRegex = regexp.MustCompile(`(?i)^(\w+)\[\s*(\w+)\s+eq\s+("[^"]*"|true|false)\s*\](?:\.(\w+))?$`)
+)
+
+// applyGroupPatch applies a single PATCH operation to the group. Only the
+// members, displayName and externalId attributes can be targeted.
+func applyGroupPatch(g *Group, op PatchOperation) error {
+	path := strings.TrimSpace(op.Path)
+
+	switch strings.ToLower(op.Op) {
+	case "add":
+		switch {
+		case strings.EqualFold(path, "members"):
+			members, err := decodeMembers(op.Value)
+			if err != nil {
+				return err
+			}
+			g.Members = append(g.Members, members...)
+		case path == "":
+			return patchGroupObject(g, op.Value, true)
+		default:
+			return setGroupAttribute(g, path, op.Value)
+		}
+	case "remove":
+		switch {
+		case memberPathRegex.MatchString(path):
+			g.Members = removeMembers(g.Members, []string{memberPathRegex.FindStringSubmatch(path)[1]})
+		case strings.EqualFold(path, "members"):
+			if len(op.Value) == 0 {
+				g.Members = []Member{}
+				return nil
+			}
+			members, err := decodeMembers(op.Value)
+			if err != nil {
+				return err
+			}
+			ids := make([]string, 0, len(members))
+			for _, m := range members {
+				ids = append(ids, m.Value)
+			}
+			g.Members = removeMembers(g.Members, ids)
+		case path == "":
+			return newError(http.StatusBadRequest, "noTarget", "remove requires a path")
+		default:
+			return newError(http.StatusBadRequest, "invalidPath", fmt.Sprintf("cannot remove %s", path))
+		}
+	case "replace":
+		switch {
+		case strings.EqualFold(path, "members"):
+			members, err := decodeMembers(op.Value)
+			if err != nil {
+				return err
+			}
+			g.Members = members
+		case path == "":
+			return patchGroupObject(g, op.Value, false)
+		default:
+			return setGroupAttribute(g, path, op.Value)
+		}
+	default:
+		return newError(http.StatusBadRequest, "invalidSyntax", fmt.Sprintf("unknown op %s", op.Op))
+	}
+
+	return nil
+}
+
+// patchGroupObject handles operations without a path where the value is a
+// partial group, e.g. {"displayName": "eng"} or {"members": [...]}.
+func patchGroupObject(g *Group, raw json.RawMessage, add bool) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return newError(http.StatusBadRequest, "invalidValue", err.Error())
+	}
+
+	for k, v := range obj {
+		if strings.EqualFold(k, "members") {
+			members, err := decodeMembers(v)
+			if err != nil {
+				return err
+			}
+			if add {
+				g.Members = append(g.Members, members...)
+			} else {
+				g.Members = members
+			}
+			continue
+		}
+		if err := setGroupAttribute(g, k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setGroupAttribute(g *Group, attr string, raw json.RawMessage) error {
+	var s string
+	switch strings.ToLower(attr) {
+	case "displayname":
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+		g.DisplayName = s
+	case "externalid":
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+		g.ExternalID = s
+	case "id", "schemas", "meta":
+		// read only, identity providers echo these back on replace
+	default:
+		return newError(http.StatusBadRequest, "invalidPath", fmt.Sprintf("unsupported attribute %s", attr))
+	}
+
+	return nil
+}
+
+func decodeMembers(raw json.RawMessage) ([]Member, error) {
+	var members []Member
+	if err := json.Unmarshal(raw, &members); err != nil {
+		// some providers send a single member object rather than a list
+		var m Member
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, newError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+		members = []Member{m}
+	}
+
+	return members, nil
+}
+
+// applyUserPatch applies a single PATCH operation to the user. The user is
+// round tripped through a map so any simple or sub-attribute path, including
+// the enterprise extension, can be targeted without listing every attribute.
+func applyUserPatch(u *User, op PatchOperation) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	var value interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", err.Error())
+		}
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if op.Path == "" {
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return newError(http.StatusBadRequest, "invalidValue", "value must be an object when no path is set")
+			}
+			for k, v := range obj {
+				if err := setPath(m, k, v); err != nil {
+					return err
+				}
+			}
+		} else if err := setPath(m, op.Path, value); err != nil {
+			return err
+		}
+	case "remove":
+		if op.Path == "" {
+			return newError(http.StatusBadRequest, "noTarget", "remove requires a path")
+		}
+		if err := setPath(m, op.Path, nil); err != nil {
+			return err
+		}
+	default:
+		return newError(http.StatusBadRequest, "invalidSyntax", fmt.Sprintf("unknown op %s", op.Op))
+	}
+
+	// Entra sends booleans as strings, e.g. "False".
+	if s, ok := m["active"].(string); ok {
+		active, err := strconv.ParseBool(s)
+		if err != nil {
+			return newError(http.StatusBadRequest, "invalidValue", fmt.Sprintf("invalid active value %q", s))
+		}
+		m["active"] = active
+	}
+
+	b, err = json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	var updated User
+	if err := json.Unmarshal(b, &updated); err != nil {
+		return newError(http.StatusBadRequest, "invalidValue", err.Error())
+	}
+	*u = updated
+
+	return nil
+}
+
+// setPath sets (or deletes when v is nil) the attribute at path. Paths may be
+// prefixed with the enterprise schema URN and may address a sub-attribute
+// such as name.givenName or an element of a multi-valued attribute such as
+// emails[type eq "work"].value.
+func setPath(m map[string]interface{}, path string, v interface{}) error {
+	target := m
+	if strings.HasPrefix(strings.ToLower(path), strings.ToLower(EnterpriseSchema)+":") {
+		path = path[len(EnterpriseSchema)+1:]
+		ext, ok := m[EnterpriseSchema].(map[string]interface{})
+		if !ok {
+			ext = map[string]interface{}{}
+			m[EnterpriseSchema] = ext
+		}
+		target = ext
+	}
+
+	if strings.ContainsAny(path, "[]") {
+		return setFiltered(target, path, v)
+	}
+
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		key := matchKey(target, p)
+		next, ok := target[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			target[key] = next
+		}
+		target = next
+	}
+
+	key := matchKey(target, parts[len(parts)-1])
+	if v == nil {
+		delete(target, key)
+		return nil
+	}
+	target[key] = v
+
+	return nil
+}
+
+// setFiltered sets (or deletes when v is nil) the elements of a multi-valued
+// attribute matching an eq value filter, or their sub-attribute when the path
+// has one. An element matching the filter is added when there is none, as
+// Entra ID sends add and replace operations for values the user does not
+// have yet, e.g. addresses[type eq "work"].locality.
+func setFiltered(m map[string]interface{}, path string, v interface{}) error {
+	match := filterPathRegex.FindStringSubmatch(path)
+	if match == nil {
+		return newError(http.StatusBadRequest, "invalidFilter", fmt.Sprintf("unsupported path %s", path))
+	}
+	key, attr, sub := matchKey(m, match[1]), match[2], match[4]
+
+	var want interface{}
+	switch f := match[3]; {
+	case strings.EqualFold(f, "true"):
+		want = true
+	case strings.EqualFold(f, "false"):
+		want = false
+	default:
+		want = f[1 : len(f)-1]
+	}
+
+	var obj map[string]interface{}
+	if v != nil && sub == "" {
+		var ok bool
+		if obj, ok = v.(map[string]interface{}); !ok {
+			return newError(http.StatusBadRequest, "invalidValue", fmt.Sprintf("value of %s must be an object", path))
+		}
+	}
+
+	list, _ := m[key].([]interface{})
+	res := []interface{}{}
+	found := false
+	for _, item := range list {
+		elem, ok := item.(map[string]interface{})
+		if !ok || !filterMatch(elem[matchKey(elem, attr)], want) {
+			res = append(res, item)
+			continue
+		}
+		found = true
+
+		switch {
+		case v == nil && sub == "":
+			continue
+		case v == nil:
+			delete(elem, matchKey(elem, sub))
+		case sub == "":
+			for k, val := range obj {
+				elem[matchKey(elem, k)] = val
+			}
+		default:
+			elem[matchKey(elem, sub)] = v
+		}
+		res = append(res, elem)
+	}
+
+	if !found && v != nil {
+		elem := map[string]interface{}{attr: want}
+		if sub == "" {
+			for k, val := range obj {
+				elem[matchKey(elem, k)] = val
+			}
+		} else {
+			elem[sub] = v
+		}
+		res = append(res, elem)
+	}
+	m[key] = res
+
+	return nil
+}
+
+// filterMatch reports whether the attribute value got equals the filter
+// value want, strings are compared case insensitively.
+func filterMatch(got, want interface{}) bool {
+	if s, ok := want.(string); ok {
+		g, ok := got.(string)
+		return ok && strings.EqualFold(g, s)
+	}
+
+	return got == want
+}
+
+// matchKey returns the existing key matching k case insensitively, as
+// attribute names are case insensitive in SCIM.
+func matchKey(m map[string]interface{}, k string) string {
+	for existing := range m {
+		if strings.EqualFold(existing, k) {
+			return existing
+		}
+	}
+
+	return k
+}
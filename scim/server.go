@@ -0,0 +1,411 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+// BasePath is the path the SCIM endpoints are served under.
+const BasePath = "/scim/v2"
+
+// Server is a SCIM 2.0 service provider backed by a Store.
+type Server struct {
+	store *Store
+	token string
+	log   logger.Logger
+}
+
+// Config represents the configuration for the SCIM server.
+type Config struct {
+	Store *Store
+	Token string
+	Log   *logger.Logger
+}
+
+// NewServer returns a Server that requires the bearer token passed.
+func NewServer(c *Config) *Server {
+	return &Server{
+		store: c.Store,
+		token: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(c.Token), "Bearer ")),
+		log:   logger.ChildLogger("scim", c.Log),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		s.writeError(w, newError(http.StatusUnauthorized, "", "invalid bearer token"))
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, BasePath), "/")
+	parts := strings.Split(path, "/")
+	s.log.Debug().Str("method", r.Method).Str("path", r.URL.Path).Msg("scim request")
+
+	switch {
+	case parts[0] == "Users" && len(parts) == 1:
+		s.users(w, r)
+	case parts[0] == "Users" && len(parts) == 2:
+		s.user(w, r, parts[1])
+	case parts[0] == "Groups" && len(parts) == 1:
+		s.groups(w, r)
+	case parts[0] == "Groups" && len(parts) == 2:
+		s.group(w, r, parts[1])
+	case parts[0] == "ServiceProviderConfig" && len(parts) == 1:
+		s.serviceProviderConfig(w)
+	default:
+		s.writeError(w, newError(http.StatusNotFound, "", fmt.Sprintf("unknown endpoint %s", r.URL.Path)))
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return false
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *Server) users(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		filter, err := parseFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		res := []User{}
+		for _, u := range s.store.Users() {
+			if filter.matchUser(&u) {
+				u.Meta.Location = location(r, "Users", u.ID)
+				res = append(res, u)
+			}
+		}
+		s.writeList(w, r, res, len(res))
+	case http.MethodPost:
+		u := User{Active: true}
+		if err := decode(r, &u); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if u.UserName == "" {
+			s.writeError(w, newError(http.StatusBadRequest, "invalidValue", "userName is required"))
+			return
+		}
+		created, err := s.store.createUser(u)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		created.Meta.Location = location(r, "Users", created.ID)
+		s.log.Info().Str("user", created.UserName).Msg("created user")
+		s.write(w, http.StatusCreated, created)
+	default:
+		s.writeError(w, newError(http.StatusMethodNotAllowed, "", "method not allowed"))
+	}
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, id string) {
+	var (
+		u   User
+		err error
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		u, err = s.store.user(id)
+	case http.MethodPut:
+		u = User{Active: true}
+		if err = decode(r, &u); err == nil {
+			u, err = s.store.replaceUser(id, u)
+		}
+	case http.MethodPatch:
+		var p PatchRequest
+		if err = decode(r, &p); err == nil {
+			u, err = s.store.updateUser(id, func(u *User) error {
+				for _, op := range p.Operations {
+					if err := applyUserPatch(u, op); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	case http.MethodDelete:
+		if err = s.store.deleteUser(id); err == nil {
+			s.log.Info().Str("id", id).Msg("deleted user")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		err = newError(http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	u.Meta.Location = location(r, "Users", u.ID)
+	s.write(w, http.StatusOK, u)
+}
+
+func (s *Server) groups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		filter, err := parseFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		excludeMembers := strings.Contains(r.URL.Query().Get("excludedAttributes"), "members")
+		res := []Group{}
+		for _, g := range s.store.Groups() {
+			if filter.matchGroup(&g) {
+				if excludeMembers {
+					g.Members = nil
+				}
+				g.Meta.Location = location(r, "Groups", g.ID)
+				res = append(res, g)
+			}
+		}
+		s.writeList(w, r, res, len(res))
+	case http.MethodPost:
+		var g Group
+		if err := decode(r, &g); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if g.DisplayName == "" {
+			s.writeError(w, newError(http.StatusBadRequest, "invalidValue", "displayName is required"))
+			return
+		}
+		created, err := s.store.createGroup(g)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		created.Meta.Location = location(r, "Groups", created.ID)
+		s.log.Info().Str("group", created.DisplayName).Msg("created group")
+		s.write(w, http.StatusCreated, created)
+	default:
+		s.writeError(w, newError(http.StatusMethodNotAllowed, "", "method not allowed"))
+	}
+}
+
+func (s *Server) group(w http.ResponseWriter, r *http.Request, id string) {
+	var (
+		g   Group
+		err error
+	)
+
+	switch r.Method {
+	case http.MethodGet:
+		g, err = s.store.group(id)
+	case http.MethodPut:
+		if err = decode(r, &g); err == nil {
+			g, err = s.store.replaceGroup(id, g)
+		}
+	case http.MethodPatch:
+		var p PatchRequest
+		if err = decode(r, &p); err == nil {
+			g, err = s.store.updateGroup(id, func(g *Group) error {
+				for _, op := range p.Operations {
+					if err := applyGroupPatch(g, op); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err == nil {
+				s.log.Info().Str("group", g.DisplayName).Int("members", len(g.Members)).Msg("patched group")
+			}
+		}
+	case http.MethodDelete:
+		if err = s.store.deleteGroup(id); err == nil {
+			s.log.Info().Str("id", id).Msg("deleted group")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		err = newError(http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	g.Meta.Location = location(r, "Groups", g.ID)
+	s.write(w, http.StatusOK, g)
+}
+
+func (s *Server) serviceProviderConfig(w http.ResponseWriter) {
+	supported := func(b bool) map[string]bool { return map[string]bool{"supported": b} }
+
+	s.write(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{SPConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": 1000},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]string{
+			{"type": "oauthbearertoken", "name": "OAuth Bearer Token", "description": "Authentication using a bearer token"},
+		},
+	})
+}
+
+// writeList pages the results using the startIndex and count query parameters.
+func (s *Server) writeList(w http.ResponseWriter, r *http.Request, resources interface{}, total int) {
+	start, count := 1, total
+	if v, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && v > 1 {
+		start = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && v >= 0 {
+		count = v
+	}
+
+	lo := start - 1
+	if lo > total {
+		lo = total
+	}
+	hi := lo + count
+	if hi > total {
+		hi = total
+	}
+
+	var page interface{}
+	switch res := resources.(type) {
+	case []User:
+		page = res[lo:hi]
+	case []Group:
+		page = res[lo:hi]
+	}
+
+	s.write(w, http.StatusOK, ListResponse{
+		Schemas:      []string{ListSchema},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: hi - lo,
+		Resources:    page,
+	})
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Info().AnErr("error", err).Msg("failed to write response")
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = newError(http.StatusInternalServerError, "", err.Error())
+	}
+	s.log.Debug().Int("status", e.status).Str("detail", e.Detail).Msg("scim error")
+	s.write(w, e.status, e)
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newError(http.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	return nil
+}
+
+func location(r *http.Request, resource, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return fmt.Sprintf("%s://%s%s/%s/%s", scheme, r.Host, BasePath, resource, id)
+}
+
+// filter is the subset of the SCIM filter grammar identity providers use
+// when reconciling: a single `attribute eq "value"` comparison.
+type filter struct {
+	attr  string
+	value string
+}
+
+var filterRegex = regexp.MustCompile(`(?i)^\s*([\w.]+)\s+eq\s+"(.*)"\s*$`)
+
+func parseFilter(f string) (*filter, error) {
+	if f == "" {
+		return nil, nil
+	}
+
+	m := filterRegex.FindStringSubmatch(f)
+	if m == nil {
+		return nil, newError(http.StatusBadRequest, "invalidFilter", fmt.Sprintf("unsupported filter %q", f))
+	}
+
+	return &filter{attr: strings.ToLower(m[1]), value: m[2]}, nil
+}
+
+func (f *filter) matchUser(u *User) bool {
+	if f == nil {
+		return true
+	}
+
+	switch f.attr {
+	case "username":
+		return strings.EqualFold(u.UserName, f.value)
+	case "externalid":
+		return u.ExternalID == f.value
+	case "id":
+		return u.ID == f.value
+	case "emails.value", "emails":
+		for _, e := range u.Emails {
+			if strings.EqualFold(e.Value, f.value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (f *filter) matchGroup(g *Group) bool {
+	if f == nil {
+		return true
+	}
+
+	switch f.attr {
+	case "displayname":
+		return strings.EqualFold(g.DisplayName, f.value)
+	case "externalid":
+		return g.ExternalID == f.value
+	case "id":
+		return g.ID == f.value
+	case "members.value", "members":
+		for _, m := range g.Members {
+			if m.Value == f.value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
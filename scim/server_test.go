@@ -0,0 +1,321 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/johnmikee/manifester/pkg/logger"
+)
+
+var log = logger.NewLogger(
+	&logger.Config{
+		ToFile:  false,
+		Level:   logger.DEBUG,
+		Service: "test",
+		Env:     "dev",
+	},
+)
+
+const token = "s3cret"
+
+func newTestServer(t *testing.T) (*httptest.Server, *Store, string) {
+	path := filepath.Join(t.TempDir(), "scim.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+
+	srv := httptest.NewServer(NewServer(&Config{Store: store, Token: token, Log: &log}))
+	t.Cleanup(srv.Close)
+
+	return srv, store, path
+}
+
+func call(t *testing.T, srv *httptest.Server, method, path string, body interface{}, v interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("Error encoding body: %s", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, srv.URL+BasePath+path, &buf)
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/scim+json")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Error decoding response: %s", err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv, _, _ := newTestServer(t)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+BasePath+"/Users", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error making request: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestProvisioning(t *testing.T) {
+	srv, store, path := newTestServer(t)
+
+	var alice, bob User
+	status := call(t, srv, http.MethodPost, "/Users", map[string]interface{}{
+		"schemas":  []string{UserSchema},
+		"userName": "alice@example.com",
+		"emails":   []Email{{Value: "alice@example.com", Primary: true}},
+	}, &alice)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, status)
+	}
+	if !alice.Active {
+		t.Errorf("Expected user to default to active")
+	}
+	call(t, srv, http.MethodPost, "/Users", map[string]interface{}{"userName": "bob@example.com"}, &bob)
+
+	t.Run("DuplicateUser", func(t *testing.T) {
+		status := call(t, srv, http.MethodPost, "/Users", map[string]interface{}{"userName": "ALICE@example.com"}, &Error{})
+		if status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+	})
+
+	t.Run("RenameToExistingUser", func(t *testing.T) {
+		status := call(t, srv, http.MethodPut, "/Users/"+bob.ID, map[string]interface{}{"userName": "alice@example.com"}, &Error{})
+		if status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+		status = call(t, srv, http.MethodPatch, "/Users/"+bob.ID, PatchRequest{
+			Schemas:    []string{PatchSchema},
+			Operations: []PatchOperation{{Op: "replace", Path: "userName", Value: json.RawMessage(`"alice@example.com"`)}},
+		}, &Error{})
+		if status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+	})
+
+	t.Run("FilterUsers", func(t *testing.T) {
+		var list ListResponse
+		call(t, srv, http.MethodGet, `/Users?filter=userName%20eq%20%22bob@example.com%22`, nil, &list)
+		if list.TotalResults != 1 {
+			t.Errorf("Expected 1 result, got %d", list.TotalResults)
+		}
+	})
+
+	var group Group
+	call(t, srv, http.MethodPost, "/Groups", map[string]interface{}{
+		"schemas":     []string{GroupSchema},
+		"displayName": "dept-eng",
+	}, &group)
+
+	t.Run("RenameToExistingGroup", func(t *testing.T) {
+		var ops Group
+		call(t, srv, http.MethodPost, "/Groups", map[string]interface{}{"displayName": "dept-ops"}, &ops)
+		status := call(t, srv, http.MethodPatch, "/Groups/"+ops.ID, PatchRequest{
+			Schemas:    []string{PatchSchema},
+			Operations: []PatchOperation{{Op: "replace", Path: "displayName", Value: json.RawMessage(`"dept-eng"`)}},
+		}, &Error{})
+		if status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+		call(t, srv, http.MethodDelete, "/Groups/"+ops.ID, nil, nil)
+	})
+
+	t.Run("PatchAddMembers", func(t *testing.T) {
+		var g Group
+		status := call(t, srv, http.MethodPatch, "/Groups/"+group.ID, PatchRequest{
+			Schemas: []string{PatchSchema},
+			Operations: []PatchOperation{
+				{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"` + alice.ID + `"},{"value":"` + bob.ID + `"}]`)},
+			},
+		}, &g)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+		}
+		if len(g.Members) != 2 {
+			t.Errorf("Expected 2 members, got %d", len(g.Members))
+		}
+	})
+
+	t.Run("PatchRemoveMember", func(t *testing.T) {
+		var g Group
+		call(t, srv, http.MethodPatch, "/Groups/"+group.ID, PatchRequest{
+			Schemas: []string{PatchSchema},
+			Operations: []PatchOperation{
+				{Op: "remove", Path: `members[value eq "` + bob.ID + `"]`},
+			},
+		}, &g)
+		if len(g.Members) != 1 || g.Members[0].Value != alice.ID {
+			t.Errorf("Expected only alice to remain, got %v", g.Members)
+		}
+	})
+
+	t.Run("PatchDeactivateUser", func(t *testing.T) {
+		var u User
+		call(t, srv, http.MethodPatch, "/Users/"+bob.ID, PatchRequest{
+			Schemas: []string{PatchSchema},
+			Operations: []PatchOperation{
+				{Op: "Replace", Value: json.RawMessage(`{"active":"False"}`)},
+			},
+		}, &u)
+		if u.Active {
+			t.Errorf("Expected user to be inactive")
+		}
+	})
+
+	t.Run("PatchEnterpriseDepartment", func(t *testing.T) {
+		var u User
+		call(t, srv, http.MethodPatch, "/Users/"+alice.ID, PatchRequest{
+			Schemas: []string{PatchSchema},
+			Operations: []PatchOperation{
+				{Op: "add", Path: EnterpriseSchema + ":department", Value: json.RawMessage(`"Engineering"`)},
+			},
+		}, &u)
+		if u.Enterprise == nil || u.Enterprise.Department != "Engineering" {
+			t.Errorf("Expected department to be set, got %+v", u.Enterprise)
+		}
+	})
+
+	t.Run("PatchEntraFilters", func(t *testing.T) {
+		// the operations Entra ID sends when the work email and office of
+		// a user change
+		var u User
+		status := call(t, srv, http.MethodPatch, "/Users/"+bob.ID, map[string]interface{}{
+			"schemas": []string{PatchSchema},
+			"Operations": []map[string]interface{}{
+				{"op": "Add", "path": `emails[type eq "work"].value`, "value": "bob@contoso.com"},
+				{"op": "Replace", "path": `addresses[type eq "work"].locality`, "value": "Berlin"},
+				{"op": "Replace", "path": "name.givenName", "value": "Bob"},
+			},
+		}, &u)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+		}
+		if len(u.Emails) != 1 || u.Emails[0].Type != "work" || u.Emails[0].Value != "bob@contoso.com" {
+			t.Errorf("Expected a work email to be added, got %v", u.Emails)
+		}
+
+		call(t, srv, http.MethodPatch, "/Users/"+bob.ID, PatchRequest{
+			Schemas: []string{PatchSchema},
+			Operations: []PatchOperation{
+				{Op: "Replace", Path: `emails[type eq "Work"].value`, Value: json.RawMessage(`"bob@fabrikam.com"`)},
+			},
+		}, &u)
+		if len(u.Emails) != 1 || u.Emails[0].Value != "bob@fabrikam.com" {
+			t.Errorf("Expected the work email to be replaced, got %v", u.Emails)
+		}
+
+		var removed User
+		call(t, srv, http.MethodPatch, "/Users/"+bob.ID, PatchRequest{
+			Schemas:    []string{PatchSchema},
+			Operations: []PatchOperation{{Op: "Remove", Path: `emails[type eq "work"]`}},
+		}, &removed)
+		if len(removed.Emails) != 0 {
+			t.Errorf("Expected the work email to be removed, got %v", removed.Emails)
+		}
+	})
+
+	t.Run("GroupMembers", func(t *testing.T) {
+		expected := map[string][]string{"dept-eng": {"alice@example.com"}}
+		if !reflect.DeepEqual(store.GroupMembers(), expected) {
+			t.Errorf("Expected %v, got %v", expected, store.GroupMembers())
+		}
+	})
+
+	t.Run("Persisted", func(t *testing.T) {
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatalf("Error reopening store: %s", err)
+		}
+		if len(reopened.Users()) != 2 || len(reopened.Groups()) != 1 {
+			t.Errorf("Expected 2 users and 1 group, got %d and %d", len(reopened.Users()), len(reopened.Groups()))
+		}
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		status := call(t, srv, http.MethodDelete, "/Users/"+alice.ID, nil, nil)
+		if status != http.StatusNoContent {
+			t.Errorf("Expected status %d, got %d", http.StatusNoContent, status)
+		}
+		g, _ := store.group(group.ID)
+		if len(g.Members) != 0 {
+			t.Errorf("Expected deleted user to be removed from group, got %v", g.Members)
+		}
+	})
+}
+
+func TestSaveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Error creating store directory: %s", err)
+	}
+	store, err := Open(filepath.Join(dir, "scim.json"))
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	alice, err := store.createUser(User{UserName: "alice@example.com", Active: true})
+	if err != nil {
+		t.Fatalf("Error creating user: %s", err)
+	}
+	group, err := store.createGroup(Group{DisplayName: "dept-eng", Members: []Member{{Value: alice.ID}}})
+	if err != nil {
+		t.Fatalf("Error creating group: %s", err)
+	}
+
+	// saving fails once the directory is gone
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Error removing store directory: %s", err)
+	}
+
+	if _, err := store.createUser(User{UserName: "bob@example.com"}); err == nil {
+		t.Errorf("Expected an error creating a user")
+	}
+	if _, err := store.replaceUser(alice.ID, User{UserName: "alice@example.org"}); err == nil {
+		t.Errorf("Expected an error replacing a user")
+	}
+	if err := store.deleteUser(alice.ID); err == nil {
+		t.Errorf("Expected an error deleting a user")
+	}
+	if _, err := store.updateGroup(group.ID, func(g *Group) error { g.Members = nil; return nil }); err == nil {
+		t.Errorf("Expected an error updating a group")
+	}
+	if err := store.deleteGroup(group.ID); err == nil {
+		t.Errorf("Expected an error deleting a group")
+	}
+
+	users := store.Users()
+	if len(users) != 1 || users[0].UserName != "alice@example.com" {
+		t.Errorf("Expected the users to be unchanged, got %v", users)
+	}
+	expected := map[string][]string{"dept-eng": {"alice@example.com"}}
+	if !reflect.DeepEqual(store.GroupMembers(), expected) {
+		t.Errorf("Expected %v, got %v", expected, store.GroupMembers())
+	}
+}
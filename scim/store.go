@@ -0,0 +1,466 @@
+package scim
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists the users and groups pushed by the identity provider to a
+// local JSON file. Every write is flushed to disk before returning so the
+// file can be read by a separate manifester run.
+type Store struct {
+	mu   sync.RWMutex
+	path string
+	data storeData
+}
+
+type storeData struct {
+	Users  map[string]*User  `json:"users"`
+	Groups map[string]*Group `json:"groups"`
+}
+
+// Open loads the store at path, creating an empty one if it does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: storeData{
+			Users:  map[string]*User{},
+			Groups: map[string]*Group{},
+		},
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to decode scim store: %w", err)
+	}
+	if s.data.Users == nil {
+		s.data.Users = map[string]*User{}
+	}
+	if s.data.Groups == nil {
+		s.data.Groups = map[string]*Group{}
+	}
+
+	return s, nil
+}
+
+// save writes the store to a temporary file and renames it into place
+// so a reader never sees a partially written file.
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".scim-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Users returns all the users sorted by userName.
+func (s *Store) Users() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.data.Users))
+	for _, u := range s.data.Users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserName < users[j].UserName })
+
+	return users
+}
+
+// Groups returns all the groups sorted by displayName.
+func (s *Store) Groups() []Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]Group, 0, len(s.data.Groups))
+	for _, g := range s.data.Groups {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].DisplayName < groups[j].DisplayName })
+
+	return groups
+}
+
+// GroupMembers returns a map of group names to the email addresses of
+// their active members.
+func (s *Store) GroupMembers() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := make(map[string][]string)
+	for _, g := range s.data.Groups {
+		for _, member := range g.Members {
+			u, ok := s.data.Users[member.Value]
+			if !ok || !u.Active {
+				continue
+			}
+			m[g.DisplayName] = append(m[g.DisplayName], u.PrimaryEmail())
+		}
+	}
+
+	return m
+}
+
+func (s *Store) user(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.data.Users[id]
+	if !ok {
+		return User{}, notFound("user", id)
+	}
+
+	return *u, nil
+}
+
+func (s *Store) createUser(u User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.uniqueUserName("", u.UserName); err != nil {
+		return User{}, err
+	}
+
+	now := time.Now().UTC()
+	u.ID = newID()
+	u.Schemas = userSchemas(&u)
+	u.Meta = Meta{ResourceType: "User", Created: now, LastModified: now}
+	if err := s.putUser(&u); err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+func (s *Store) replaceUser(id string, u User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.data.Users[id]
+	if !ok {
+		return User{}, notFound("user", id)
+	}
+	if err := s.uniqueUserName(id, u.UserName); err != nil {
+		return User{}, err
+	}
+
+	u.ID = id
+	u.Schemas = userSchemas(&u)
+	u.Meta = existing.Meta
+	u.Meta.LastModified = time.Now().UTC()
+	if err := s.putUser(&u); err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+func (s *Store) deleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.data.Users[id]
+	if !ok {
+		return notFound("user", id)
+	}
+	delete(s.data.Users, id)
+
+	// drop the user from any group they were a member of
+	members := make(map[string][]Member, len(s.data.Groups))
+	for gid, g := range s.data.Groups {
+		members[gid] = g.Members
+		g.Members = removeMembers(g.Members, []string{id})
+	}
+
+	if err := s.save(); err != nil {
+		s.data.Users[id] = u
+		for gid, m := range members {
+			s.data.Groups[gid].Members = m
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) group(id string) (Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.data.Groups[id]
+	if !ok {
+		return Group{}, notFound("group", id)
+	}
+
+	return *g, nil
+}
+
+func (s *Store) createGroup(g Group) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.uniqueDisplayName("", g.DisplayName); err != nil {
+		return Group{}, err
+	}
+
+	now := time.Now().UTC()
+	g.ID = newID()
+	g.Schemas = []string{GroupSchema}
+	g.Members = dedupeMembers(g.Members)
+	g.Meta = Meta{ResourceType: "Group", Created: now, LastModified: now}
+	if err := s.putGroup(&g); err != nil {
+		return Group{}, err
+	}
+
+	return g, nil
+}
+
+func (s *Store) replaceGroup(id string, g Group) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.data.Groups[id]
+	if !ok {
+		return Group{}, notFound("group", id)
+	}
+	if err := s.uniqueDisplayName(id, g.DisplayName); err != nil {
+		return Group{}, err
+	}
+
+	g.ID = id
+	g.Schemas = []string{GroupSchema}
+	g.Members = dedupeMembers(g.Members)
+	g.Meta = existing.Meta
+	g.Meta.LastModified = time.Now().UTC()
+	if err := s.putGroup(&g); err != nil {
+		return Group{}, err
+	}
+
+	return g, nil
+}
+
+func (s *Store) deleteGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.data.Groups[id]
+	if !ok {
+		return notFound("group", id)
+	}
+	delete(s.data.Groups, id)
+
+	if err := s.save(); err != nil {
+		s.data.Groups[id] = g
+		return err
+	}
+
+	return nil
+}
+
+// updateGroup applies fn to a copy of the group and stores the result if fn succeeds.
+func (s *Store) updateGroup(id string, fn func(g *Group) error) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.data.Groups[id]
+	if !ok {
+		return Group{}, notFound("group", id)
+	}
+
+	g := *existing
+	g.Members = append([]Member(nil), existing.Members...)
+	if err := fn(&g); err != nil {
+		return Group{}, err
+	}
+	if err := s.uniqueDisplayName(id, g.DisplayName); err != nil {
+		return Group{}, err
+	}
+	g.Members = dedupeMembers(g.Members)
+	g.Meta.LastModified = time.Now().UTC()
+	if err := s.putGroup(&g); err != nil {
+		return Group{}, err
+	}
+
+	return g, nil
+}
+
+// updateUser applies fn to a copy of the user and stores the result if fn succeeds.
+func (s *Store) updateUser(id string, fn func(u *User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.data.Users[id]
+	if !ok {
+		return User{}, notFound("user", id)
+	}
+
+	u := *existing
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	if err := s.uniqueUserName(id, u.UserName); err != nil {
+		return User{}, err
+	}
+	u.ID = id
+	u.Schemas = userSchemas(&u)
+	u.Meta = existing.Meta
+	u.Meta.LastModified = time.Now().UTC()
+	if err := s.putUser(&u); err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// putUser stores the user and saves the store. The previous user is
+// restored when the store cannot be saved so memory always matches disk.
+func (s *Store) putUser(u *User) error {
+	prev, ok := s.data.Users[u.ID]
+	s.data.Users[u.ID] = u
+	if err := s.save(); err != nil {
+		if ok {
+			s.data.Users[u.ID] = prev
+		} else {
+			delete(s.data.Users, u.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// putGroup stores the group and saves the store. The previous group is
+// restored when the store cannot be saved so memory always matches disk.
+func (s *Store) putGroup(g *Group) error {
+	prev, ok := s.data.Groups[g.ID]
+	s.data.Groups[g.ID] = g
+	if err := s.save(); err != nil {
+		if ok {
+			s.data.Groups[g.ID] = prev
+		} else {
+			delete(s.data.Groups, g.ID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// uniqueUserName returns a uniqueness error when a user other than id has
+// the userName.
+func (s *Store) uniqueUserName(id, userName string) error {
+	for existingID, existing := range s.data.Users {
+		if existingID != id && strings.EqualFold(existing.UserName, userName) {
+			return newError(http.StatusConflict, "uniqueness", fmt.Sprintf("userName %s already exists", userName))
+		}
+	}
+
+	return nil
+}
+
+// uniqueDisplayName returns a uniqueness error when a group other than id
+// has the displayName.
+func (s *Store) uniqueDisplayName(id, displayName string) error {
+	for existingID, existing := range s.data.Groups {
+		if existingID != id && strings.EqualFold(existing.DisplayName, displayName) {
+			return newError(http.StatusConflict, "uniqueness", fmt.Sprintf("group %s already exists", displayName))
+		}
+	}
+
+	return nil
+}
+
+func userSchemas(u *User) []string {
+	if u.Enterprise != nil {
+		return []string{UserSchema, EnterpriseSchema}
+	}
+
+	return []string{UserSchema}
+}
+
+func dedupeMembers(members []Member) []Member {
+	seen := make(map[string]bool)
+	res := []Member{}
+	for _, m := range members {
+		if m.Value == "" || seen[m.Value] {
+			continue
+		}
+		seen[m.Value] = true
+		res = append(res, m)
+	}
+
+	return res
+}
+
+func removeMembers(members []Member, ids []string) []Member {
+	res := []Member{}
+	for _, m := range members {
+		remove := false
+		for _, id := range ids {
+			if m.Value == id {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			res = append(res, m)
+		}
+	}
+
+	return res
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func newError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+		status:   status,
+	}
+}
+
+func notFound(resource, id string) *Error {
+	return newError(http.StatusNotFound, "", fmt.Sprintf("%s %s not found", resource, id))
+}